@@ -0,0 +1,123 @@
+package defs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// asnZoneV4 and asnZoneV6 are the DNS zones mapping the addresses to the AS announcing them, see
+	// https://team-cymru.com/community-services/ip-asn-mapping/
+	asnZoneV4 = "origin.asn.cymru.com"
+	asnZoneV6 = "origin6.asn.cymru.com"
+
+	// asnLookupTimeout is the time allowed for looking up the AS of a hop
+	asnLookupTimeout = 3 * time.Second
+)
+
+// Backbone represents an ISP backbone network, recognized by the AS its routers are announced from
+type Backbone struct {
+	Name string
+	ASN  uint32
+	ISP  uint8
+}
+
+// Backbones are the named backbone networks of the ISPs in ISPMap. The hops in the other ASes of ISPMap are mapped to
+// the ISP itself
+var Backbones = []*Backbone{
+	{Name: "CN2", ASN: 4809, ISP: TELECOM.ID},
+	{Name: "163", ASN: uint32(TELECOM.ASN), ISP: TELECOM.ID},
+	{Name: "CUII", ASN: 9929, ISP: UNICOM.ID},
+	{Name: "169", ASN: uint32(UNICOM.ASN), ISP: UNICOM.ID},
+	{Name: "CMI", ASN: 58453, ISP: MOBILE.ID},
+	{Name: "CMNET", ASN: uint32(MOBILE.ASN), ISP: MOBILE.ID},
+}
+
+// String returns the name of the backbone together with its ASN and ISP, e.g. `CN2 (AS4809, 电信)`
+func (b *Backbone) String() string {
+	return fmt.Sprintf("%s (AS%d, %s)", b.Name, b.ASN, ISPMap[b.ISP].Name)
+}
+
+// LookupBackbone returns the backbone network the given IP belongs to, or nil if it is not a known backbone router.
+// The AS of public addresses is looked up over DNS
+func LookupBackbone(ctx context.Context, ip string) *Backbone {
+	addr := net.ParseIP(ip)
+	if addr == nil || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, asnLookupTimeout)
+	defer cancel()
+
+	records, err := net.DefaultResolver.LookupTXT(ctx, asnQuery(addr))
+	if err != nil {
+		log.Debugf("Failed to look up the AS of %s: %s", ip, err)
+		return nil
+	}
+	asn, err := parseASN(records)
+	if err != nil {
+		log.Debugf("Failed to look up the AS of %s: %s", ip, err)
+		return nil
+	}
+
+	return BackboneByASN(asn)
+}
+
+// BackboneByASN returns the backbone network of the given AS, or nil if it is not an AS of the ISPs in ISPMap
+func BackboneByASN(asn uint32) *Backbone {
+	for _, b := range Backbones {
+		if b.ASN == asn {
+			return b
+		}
+	}
+
+	for _, isp := range ISPMap {
+		if isp.ID != 0 && uint32(isp.ASN) == asn {
+			return &Backbone{Name: isp.Code, ASN: asn, ISP: isp.ID}
+		}
+	}
+
+	return nil
+}
+
+// asnQuery returns the name to query for the AS of the IP, e.g. `1.0.43.59.origin.asn.cymru.com` for 59.43.0.1
+func asnQuery(ip net.IP) string {
+	var labels []string
+	if v4 := ip.To4(); v4 != nil {
+		for i := len(v4) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(v4[i])))
+		}
+		return strings.Join(append(labels, asnZoneV4), ".")
+	}
+
+	v6 := ip.To16()
+	for i := len(v6) - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(v6[i]&0xf), 16), strconv.FormatUint(uint64(v6[i]>>4), 16))
+	}
+	return strings.Join(append(labels, asnZoneV6), ".")
+}
+
+// parseASN returns the AS from the answer of an AS query, e.g. `4809 | 59.43.0.0/16 | CN | apnic | 2000-01-01`. The
+// first one is used if the prefix is announced from several ASes
+func parseASN(records []string) (uint32, error) {
+	for _, record := range records {
+		fields := strings.Fields(strings.SplitN(record, "|", 2)[0])
+		if len(fields) == 0 {
+			continue
+		}
+		asn, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid AS record %q", record)
+		}
+		return uint32(asn), nil
+	}
+
+	return 0, errors.New("no AS record")
+}
@@ -0,0 +1,83 @@
+package defs
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestBackboneByASN(t *testing.T) {
+	tests := []struct {
+		asn  uint32
+		want string
+	}{
+		{4809, "CN2 (AS4809, 电信)"},
+		{4134, "163 (AS4134, 电信)"},
+		{9929, "CUII (AS9929, 联通)"},
+		{4837, "169 (AS4837, 联通)"},
+		{58453, "CMI (AS58453, 移动)"},
+		{9808, "CMNET (AS9808, 移动)"},
+		{4538, "CERNET (AS4538, 教育网)"},
+		{17964, "DXTNET (AS17964, 鹏博士)"},
+		{13335, ""},
+		{0, ""},
+	}
+
+	for _, tt := range tests {
+		var got string
+		if b := BackboneByASN(tt.asn); b != nil {
+			got = b.String()
+		}
+		if got != tt.want {
+			t.Errorf("BackboneByASN(%d) = %q, want %q", tt.asn, got, tt.want)
+		}
+	}
+}
+
+func TestASNQuery(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"59.43.0.1", "1.0.43.59.origin.asn.cymru.com"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.origin6.asn.cymru.com"},
+	}
+
+	for _, tt := range tests {
+		if got := asnQuery(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("asnQuery(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []string
+		want    uint32
+		wantErr bool
+	}{
+		{"single origin", []string{"4809 | 59.43.0.0/16 | CN | apnic | 2000-01-01"}, 4809, false},
+		{"several origins", []string{"4134 4809 | 202.97.0.0/16 | CN | apnic | "}, 4134, false},
+		{"empty record", []string{"", "9929 | 218.105.0.0/16 | CN | apnic | "}, 9929, false},
+		{"no record", nil, 0, true},
+		{"malformed", []string{"AS4809 | 59.43.0.0/16"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseASN(tt.records)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseASN(%q) = %d, %v; want %d, error %t", tt.records, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLookupBackboneSkipsLocalAddresses(t *testing.T) {
+	for _, ip := range []string{"", "invalid", "10.0.0.1", "192.168.1.1", "127.0.0.1", "fe80::1", "fd00::1"} {
+		if b := LookupBackbone(context.Background(), ip); b != nil {
+			t.Errorf("LookupBackbone(%q) = %s, want nil", ip, b)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package defs
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// backendFileSize is the size of the file served by the download endpoints, as `File(1G).dl` suggests
+	backendFileSize = 1 << 30
+	// backendChunkSize is the size of the random data which the downloads are made of
+	backendChunkSize = 1 << 20
+	// backendKeyTTL is how long a GlobalSpeed queue key stays valid if it is not released
+	backendKeyTTL = 5 * time.Minute
+)
+
+// BackendOptions represents the parameters of the speed test backend
+type BackendOptions struct {
+	// Types are the server types whose endpoints are served, all of them if empty
+	Types []ServerType
+	// MaxClients is the number of GlobalSpeed tests allowed to be queued at the same time, 0 for no limit
+	MaxClients int
+}
+
+// Backend implements the HTTP endpoints of the speed test servers the client speaks to, i.e. GlobalSpeed with its
+// `/speed/dovalid` queue, Perception and WirelessSpeed
+type Backend struct {
+	opts  BackendOptions
+	mux   *http.ServeMux
+	chunk []byte
+
+	lock sync.Mutex
+	keys map[string]time.Time
+}
+
+// NewBackend creates a new speed test backend
+func NewBackend(opts BackendOptions) *Backend {
+	b := &Backend{
+		opts:  opts,
+		mux:   http.NewServeMux(),
+		chunk: make([]byte, backendChunkSize),
+		keys:  make(map[string]time.Time),
+	}
+	rand.Read(b.chunk)
+
+	if b.serves(GlobalSpeed) {
+		b.mux.HandleFunc("/speed/", b.ping)
+		b.mux.HandleFunc("/speed/dovalid", b.queue)
+		b.mux.HandleFunc("/speed/File(1G).dl", b.withKey(b.download))
+		b.mux.HandleFunc("/speed/doAnalsLoad.do", b.withKey(b.upload))
+	}
+	if b.serves(Perception) {
+		b.mux.HandleFunc("/speedtest/ping", b.ping)
+		b.mux.HandleFunc("/speedtest/download", b.download)
+		b.mux.HandleFunc("/speedtest/upload", b.upload)
+	}
+	if b.serves(WirelessSpeed) {
+		b.mux.HandleFunc("/GSpeedTestServer/", b.ping)
+		b.mux.HandleFunc("/GSpeedTestServer/download", b.download)
+		b.mux.HandleFunc("/GSpeedTestServer/upload", b.upload)
+	}
+
+	return b
+}
+
+// ServeHTTP implements http.Handler
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("%s %s %s from %s", r.Proto, r.Method, r.URL.RequestURI(), r.RemoteAddr)
+	b.mux.ServeHTTP(w, r)
+}
+
+// serves checks whether the endpoints of the given server type are served
+func (b *Backend) serves(t ServerType) bool {
+	if len(b.opts.Types) == 0 {
+		return true
+	}
+	for _, st := range b.opts.Types {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ping replies with an empty 200 response to the ping requests
+func (b *Backend) ping(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/speed/" && r.URL.Path != "/GSpeedTestServer/" && r.URL.Path != "/speedtest/ping" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// download sends the random content of a file of backendFileSize bytes
+func (b *Backend) download(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(backendFileSize))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	for left := backendFileSize; left > 0; left -= len(b.chunk) {
+		n := len(b.chunk)
+		if left < n {
+			n = left
+		}
+		if _, err := w.Write(b.chunk[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// upload discards the request body, and replies with the number of bytes received
+func (b *Backend) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, _ := io.Copy(io.Discard, r.Body)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, n)
+}
+
+// queue implements the GlobalSpeed queue. A GET request with a token signing the model, IMEI and time enqueues a test
+// and returns a key prefixed by 2 characters, or `-` if the queue is full, and a POST request with the key dequeues it
+func (b *Backend) queue(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch r.Method {
+	case http.MethodGet:
+		sum := md5.Sum([]byte(fmt.Sprintf("model=%s&imei=%s&stime=%s", query.Get("model"), query.Get("imei"), query.Get("time"))))
+		if hex.EncodeToString(sum[:]) != query.Get("token") {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+
+		key, ok := b.enqueue()
+		if !ok {
+			log.Debugf("Queue is full, rejecting %s", r.RemoteAddr)
+			fmt.Fprint(w, "0|-")
+			return
+		}
+		fmt.Fprintf(w, "1|%s", key)
+	case http.MethodPost:
+		if !b.dequeue(query.Get("key")) {
+			http.Error(w, "unknown key", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "1")
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// withKey only allows the requests with a queued key, given in the query or the `Key` header
+func (b *Backend) withKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			key = r.Header.Get("Key")
+		}
+
+		b.lock.Lock()
+		_, ok := b.keys[key]
+		b.lock.Unlock()
+
+		if !ok {
+			http.Error(w, "invalid key", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// enqueue returns a new key, or false if MaxClients keys are in use
+func (b *Backend) enqueue() (string, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	for key, at := range b.keys {
+		if now.Sub(at) > backendKeyTTL {
+			delete(b.keys, key)
+		}
+	}
+	if b.opts.MaxClients > 0 && len(b.keys) >= b.opts.MaxClients {
+		return "", false
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	key := hex.EncodeToString(buf)
+	b.keys[key] = now
+	return key, true
+}
+
+// dequeue releases a key, and returns false if it is unknown
+func (b *Backend) dequeue(key string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.keys[key]; !ok {
+		return false
+	}
+	delete(b.keys, key)
+	return true
+}
@@ -0,0 +1,94 @@
+package defs
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// queueTarget returns the target of a GlobalSpeed queue request signed with the given token, a valid one if empty
+func queueTarget(token string) string {
+	model, imei, stime := "test", "000000000000000", "1700000000"
+	if token == "" {
+		sum := md5.Sum([]byte(fmt.Sprintf("model=%s&imei=%s&stime=%s", model, imei, stime)))
+		token = hex.EncodeToString(sum[:])
+	}
+	return fmt.Sprintf("/speed/dovalid?model=%s&imei=%s&time=%s&token=%s", model, imei, stime, token)
+}
+
+func TestBackendQueue(t *testing.T) {
+	type step struct {
+		method string
+		// target is the request target, with `{key}` replaced by the key of the last queued test
+		target     string
+		wantStatus int
+		wantBody   string
+	}
+
+	tests := []struct {
+		name  string
+		opts  BackendOptions
+		steps []step
+	}{
+		{"valid token", BackendOptions{}, []step{
+			{http.MethodGet, queueTarget(""), http.StatusOK, "1|"},
+		}},
+		{"invalid token", BackendOptions{}, []step{
+			{http.MethodGet, queueTarget("0123456789abcdef0123456789abcdef"), http.StatusForbidden, "invalid token"},
+		}},
+		{"missing token", BackendOptions{}, []step{
+			{http.MethodGet, "/speed/dovalid", http.StatusForbidden, "invalid token"},
+		}},
+		{"queue full", BackendOptions{MaxClients: 1}, []step{
+			{http.MethodGet, queueTarget(""), http.StatusOK, "1|"},
+			{http.MethodGet, queueTarget(""), http.StatusOK, "0|-"},
+		}},
+		{"dequeue frees the queue", BackendOptions{MaxClients: 1}, []step{
+			{http.MethodGet, queueTarget(""), http.StatusOK, "1|"},
+			{http.MethodPost, "/speed/dovalid?key={key}", http.StatusOK, "1"},
+			{http.MethodGet, queueTarget(""), http.StatusOK, "1|"},
+		}},
+		{"dequeue unknown key", BackendOptions{}, []step{
+			{http.MethodPost, "/speed/dovalid?key=unknown", http.StatusNotFound, "unknown key"},
+		}},
+		{"download with key", BackendOptions{}, []step{
+			{http.MethodGet, queueTarget(""), http.StatusOK, "1|"},
+			{http.MethodHead, "/speed/File(1G).dl?key={key}", http.StatusOK, ""},
+		}},
+		{"download without key", BackendOptions{}, []step{
+			{http.MethodHead, "/speed/File(1G).dl", http.StatusForbidden, ""},
+		}},
+		{"download with released key", BackendOptions{}, []step{
+			{http.MethodGet, queueTarget(""), http.StatusOK, "1|"},
+			{http.MethodPost, "/speed/dovalid?key={key}", http.StatusOK, "1"},
+			{http.MethodHead, "/speed/File(1G).dl?key={key}", http.StatusForbidden, ""},
+		}},
+		{"GlobalSpeed not served", BackendOptions{Types: []ServerType{Perception}}, []step{
+			{http.MethodGet, queueTarget(""), http.StatusNotFound, ""},
+			{http.MethodHead, "/speedtest/download", http.StatusOK, ""},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBackend(tt.opts)
+			var key string
+			for i, s := range tt.steps {
+				w := httptest.NewRecorder()
+				b.ServeHTTP(w, httptest.NewRequest(s.method, strings.ReplaceAll(s.target, "{key}", key), nil))
+
+				body := w.Body.String()
+				if w.Code != s.wantStatus || !strings.HasPrefix(body, s.wantBody) {
+					t.Fatalf("step %d: %s %s = %d %q, want %d %q", i, s.method, s.target, w.Code, body, s.wantStatus, s.wantBody)
+				}
+				if strings.HasPrefix(body, "1|") {
+					key = strings.TrimPrefix(body, "1|")
+				}
+			}
+		})
+	}
+}
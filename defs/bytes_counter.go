@@ -1,24 +1,43 @@
 package defs
 
 import (
-	"bytes"
 	"crypto/rand"
 	"fmt"
 	"io"
-	"log"
+	"maps"
+	"math"
 	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// Sample represents the throughput measured within one sampling interval
+type Sample struct {
+	Offset float64 `json:"offset"`
+	Bytes  uint64  `json:"bytes"`
+	Speed  float64 `json:"speed"`
+}
+
 // BytesCounter implements io.Reader and io.Writer interface, for counting bytes being read/written in HTTP requests
 type BytesCounter struct {
 	start      time.Time
+	end        time.Time
 	pos        int
 	total      uint64
 	payload    []byte
 	reader     io.ReadSeeker
 	mebi       bool
 	uploadSize int
+	interval   time.Duration
+	samples    []Sample
+	onSample   func(Sample, uint64)
+	stop       chan struct{}
+	limit      uint64
+	pending    uint64
+	full       chan struct{}
+	conns      []*ConnStats
+	protocols  map[string]int
 
 	lock *sync.Mutex
 }
@@ -33,7 +52,7 @@ func NewCounter() *BytesCounter {
 func (c *BytesCounter) Write(p []byte) (int, error) {
 	n := len(p)
 	c.lock.Lock()
-	c.total += uint64(n)
+	c.add(uint64(n), nil)
 	c.lock.Unlock()
 
 	return n, nil
@@ -41,18 +60,226 @@ func (c *BytesCounter) Write(p []byte) (int, error) {
 
 // Read implements io.Reader
 func (c *BytesCounter) Read(p []byte) (int, error) {
-	n, err := c.reader.Read(p)
+	return c.read(p, nil)
+}
+
+// read reads the upload payload and counts the bytes for the source `src` and its connection if given. The payload is
+// shared by all connections, so only the position within it is claimed with the lock held, and the bytes are copied
+// without it
+func (c *BytesCounter) read(p []byte, src *connReader) (int, error) {
 	c.lock.Lock()
-	c.total += uint64(n)
-	c.pos += n
-	if c.pos == c.uploadSize {
-		c.resetReader()
+	size := len(p)
+	off := c.pos
+	if len(c.payload) > 0 {
+		// a read never wraps around the end of the payload
+		size = min(size, len(c.payload)-off)
+		c.pos = (off + size) % len(c.payload)
 	}
+	size = c.hold(size)
 	c.lock.Unlock()
 
+	if size == 0 {
+		return 0, io.EOF
+	}
+
+	var n int
+	var err error
+	if len(c.payload) > 0 {
+		n = copy(p, c.payload[off:off+size])
+	} else {
+		n, err = c.reader.Read(p[:size])
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.release(size)
+	if src == nil {
+		c.add(uint64(n), nil)
+		return n, err
+	}
+	if src.discarded {
+		return 0, io.EOF
+	}
+	src.n += uint64(n)
+	c.add(uint64(n), src.conn)
+
+	return n, err
+}
+
+// Source returns a reader of the upload payload that counts the bytes for the connection `conn`
+func (c *BytesCounter) Source(conn *ConnStats) io.Reader {
+	return c.source(conn)
+}
+
+// source is Source returning the reader itself, which can be discarded
+func (c *BytesCounter) source(conn *ConnStats) *connReader {
+	return &connReader{c: c, conn: conn}
+}
+
+// connReader is an io.Reader reading the upload payload on behalf of a connection
+type connReader struct {
+	c         *BytesCounter
+	conn      *ConnStats
+	n         uint64
+	discarded bool
+}
+
+// Read implements io.Reader
+func (r *connReader) Read(p []byte) (int, error) {
+	return r.c.read(p, r)
+}
+
+// discard uncounts the bytes read so far, which were sent by a failed request, and ends the reader. The samples already
+// taken are left as they are
+func (r *connReader) discard() {
+	r.c.lock.Lock()
+	defer r.c.lock.Unlock()
+
+	r.c.total -= r.n
+	if r.conn != nil {
+		r.conn.Bytes -= r.n
+	}
+	r.n = 0
+	r.discarded = true
+}
+
+// Wrap returns a reader that counts the bytes read from `r` for the connection `conn`, and stops reading once the
+// limit is reached
+func (c *BytesCounter) Wrap(r io.Reader, conn *ConnStats) io.Reader {
+	return &countingReader{r: r, c: c, conn: conn}
+}
+
+// countingReader is an io.Reader counting bytes being read from the wrapped reader
+type countingReader struct {
+	r    io.Reader
+	c    *BytesCounter
+	conn *ConnStats
+}
+
+// Read implements io.Reader
+func (r *countingReader) Read(p []byte) (int, error) {
+	size := r.c.reserve(len(p))
+	if size == 0 {
+		return 0, io.EOF
+	}
+
+	n, err := r.r.Read(p[:size])
+	r.c.lock.Lock()
+	r.c.release(size)
+	r.c.add(uint64(n), r.conn)
+	r.c.lock.Unlock()
+
 	return n, err
 }
 
+// newConn registers a new connection and returns its statistics
+func (c *BytesCounter) newConn() *ConnStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	conn := &ConnStats{}
+	c.conns = append(c.conns, conn)
+	return conn
+}
+
+// track updates the statistics of a connection
+func (c *BytesCounter) track(conn *ConnStats, update func(*ConnStats)) {
+	c.lock.Lock()
+	update(conn)
+	c.lock.Unlock()
+}
+
+// Conns returns the statistics of each connection
+func (c *BytesCounter) Conns() []ConnStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	conns := make([]ConnStats, len(c.conns))
+	for i, conn := range c.conns {
+		conns[i] = *conn
+	}
+	return conns
+}
+
+// countProtocol counts a response received over the protocol `proto`
+func (c *BytesCounter) countProtocol(proto string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.protocols == nil {
+		c.protocols = make(map[string]int)
+	}
+	c.protocols[proto]++
+}
+
+// Protocols returns the number of responses received over each protocol
+func (c *BytesCounter) Protocols() map[string]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return maps.Clone(c.protocols)
+}
+
+// allowed returns how many of the `size` bytes can still be read/written within the limit, must be called with the
+// lock held
+func (c *BytesCounter) allowed(size int) int {
+	if c.limit == 0 {
+		return size
+	}
+
+	if remaining := c.limit - c.total - c.pending; uint64(size) > remaining {
+		size = int(remaining)
+	}
+	return size
+}
+
+// reserve returns how many of the `size` bytes can still be read/written within the limit, and holds them until
+// release is called so concurrent readers cannot exceed the limit together
+func (c *BytesCounter) reserve(size int) int {
+	if c.limit == 0 {
+		return size
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.hold(size)
+}
+
+// hold is reserve with the lock already held
+func (c *BytesCounter) hold(size int) int {
+	size = c.allowed(size)
+	if c.limit > 0 {
+		c.pending += uint64(size)
+	}
+	return size
+}
+
+// release returns the bytes held by reserve, must be called with the lock held
+func (c *BytesCounter) release(size int) {
+	if c.limit > 0 {
+		c.pending -= uint64(size)
+	}
+}
+
+// add adds `n` bytes to the total and to the connection `conn` if given, and signals once the limit is reached, must be
+// called with the lock held
+func (c *BytesCounter) add(n uint64, conn *ConnStats) {
+	c.total += n
+	if conn != nil {
+		conn.Bytes += n
+	}
+	if c.limit > 0 && n > 0 && c.total >= c.limit && c.total-n < c.limit {
+		// the limit can be reached again after discarded bytes are uncounted
+		select {
+		case <-c.full:
+		default:
+			close(c.full)
+		}
+	}
+}
+
 // SetMebi sets the base for dividing bytes into megabyte or mebibyte
 func (c *BytesCounter) SetMebi(mebi bool) {
 	c.mebi = mebi
@@ -63,24 +290,73 @@ func (c *BytesCounter) SetUploadSize(uploadSize int) {
 	c.uploadSize = uploadSize * 1024
 }
 
+// SetLimit sets the maximum bytes to be read/written, 0 means unlimited
+func (c *BytesCounter) SetLimit(limit uint64) {
+	c.limit = limit
+	if limit > 0 {
+		c.full = make(chan struct{})
+	}
+}
+
+// Full returns a channel that is closed once the limit is reached, or nil if no limit is set
+func (c *BytesCounter) Full() <-chan struct{} {
+	return c.full
+}
+
+// Limit returns the maximum bytes to be read/written
+func (c *BytesCounter) Limit() uint64 {
+	return c.limit
+}
+
+// SetInterval sets the interval between throughput samples, 0 disables sampling
+func (c *BytesCounter) SetInterval(interval time.Duration) {
+	c.interval = interval
+}
+
+// SetOnSample sets the function called with each throughput sample and the total bytes read/written so far
+func (c *BytesCounter) SetOnSample(fn func(Sample, uint64)) {
+	c.onSample = fn
+}
+
 // AvgBytes returns the average bytes/second
 func (c *BytesCounter) AvgBytes() float64 {
-	return float64(c.total) / time.Since(c.start).Seconds()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return float64(c.total) / c.elapsed().Seconds()
 }
 
 // AvgMbps returns the average mbits/second
 func (c *BytesCounter) AvgMbps() float64 {
+	return c.toMbps(c.AvgBytes())
+}
+
+// toMbps converts bytes/second to mbits/second
+func (c *BytesCounter) toMbps(val float64) float64 {
 	var base float64 = 125000
 	if c.mebi {
 		base = 131072
 	}
-	return c.AvgBytes() / base
+	return val / base
 }
 
 // AvgHumanize returns the average bytes/kilobytes/megabytes/gigabytes (or bytes/kibibytes/mebibytes/gibibytes) per second
 func (c *BytesCounter) AvgHumanize() string {
-	val := c.AvgBytes()
+	return c.humanize(c.AvgBytes())
+}
 
+// Humanize returns the given mbits/second in bytes/kilobytes/megabytes/gigabytes (or bytes/kibibytes/mebibytes/gibibytes)
+// per second
+func (c *BytesCounter) Humanize(mbps float64) string {
+	var base float64 = 125000
+	if c.mebi {
+		base = 131072
+	}
+	return c.humanize(mbps * base)
+}
+
+// humanize returns the given bytes/second in a human-readable form
+func (c *BytesCounter) humanize(val float64) string {
 	var base float64 = 1000
 	if c.mebi {
 		base = 1024
@@ -99,22 +375,43 @@ func (c *BytesCounter) AvgHumanize() string {
 
 // Bytes returns the Bytes
 func (c *BytesCounter) Bytes() float64 {
-	return float64(c.total) / 8
+	return float64(c.Total())
 }
 
 // MBytes returns the MBytes
 func (c *BytesCounter) MBytes() float64 {
-	var base float64 = 125000
+	return c.toMB(c.Bytes())
+}
+
+// toMB converts bytes to megabytes (or mebibytes)
+func (c *BytesCounter) toMB(val float64) float64 {
+	var base float64 = 1000000
 	if c.mebi {
-		base = 131072
+		base = 1048576
 	}
-	return c.Bytes() / base
+	return val / base
 }
 
 // BytesHumanize returns the Bytes/KiloBytes/MegaBytes/GigaBytes (or Bytes/KibiBytes/MebiBytes/GibiBytes)
 func (c *BytesCounter) BytesHumanize() string {
-	val := c.Bytes()
+	return c.bytesHumanize(c.Bytes())
+}
 
+// DataUsed returns the data used in MB or in a human-readable form, together with the limit if set
+func (c *BytesCounter) DataUsed(useBytes bool) string {
+	used, limit := fmt.Sprintf("%.2f MB", c.MBytes()), fmt.Sprintf("%.2f MB", c.toMB(float64(c.limit)))
+	if useBytes {
+		used, limit = c.BytesHumanize(), c.bytesHumanize(float64(c.limit))
+	}
+
+	if c.limit > 0 {
+		return fmt.Sprintf("%s of %s limit in %.2f s", used, limit, c.Elapsed().Seconds())
+	}
+	return used
+}
+
+// bytesHumanize returns the given bytes in a human-readable form
+func (c *BytesCounter) bytesHumanize(val float64) string {
 	var base float64 = 1000
 	if c.mebi {
 		base = 1024
@@ -131,32 +428,203 @@ func (c *BytesCounter) BytesHumanize() string {
 	}
 }
 
-// GenerateBlob generates a random byte array of `uploadSize` in the `payload` field, and sets the `reader` field to
-// read from it
+// GenerateBlob generates a random byte array of `uploadSize` in the `payload` field, which is read over and over as the
+// upload payload
 func (c *BytesCounter) GenerateBlob() {
 	c.payload = getRandomData(c.uploadSize)
-	c.reader = bytes.NewReader(c.payload)
 }
 
-// resetReader resets the `reader` field to 0 position
-func (c *BytesCounter) resetReader() (int64, error) {
-	c.pos = 0
-	return c.reader.Seek(0, 0)
-}
-
-// Start will set the `start` field to current time
+// Start will set the `start` field to current time, and start sampling the throughput if an interval is set
 func (c *BytesCounter) Start() {
 	c.start = time.Now()
+	if c.interval > 0 {
+		c.stop = make(chan struct{})
+		go c.sample()
+	}
+}
+
+// Stop will set the `end` field to current time and stop sampling the throughput
+func (c *BytesCounter) Stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.end.IsZero() {
+		return
+	}
+	c.end = time.Now()
+	if c.stop != nil {
+		close(c.stop)
+	}
+}
+
+// Elapsed returns the time elapsed since start, or the test duration once stopped
+func (c *BytesCounter) Elapsed() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.elapsed()
+}
+
+// elapsed is Elapsed with the lock already held
+func (c *BytesCounter) elapsed() time.Duration {
+	if c.end.IsZero() {
+		return time.Since(c.start)
+	}
+	return c.end.Sub(c.start)
+}
+
+// sample records the bytes read/written within every `interval` until the counter is stopped
+func (c *BytesCounter) sample() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	var last uint64
+	lastTime := c.start
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.lock.Lock()
+			if !c.end.IsZero() {
+				c.lock.Unlock()
+				return
+			}
+			// the total drops when the bytes of a failed request are discarded
+			var n uint64
+			if c.total > last {
+				n = c.total - last
+			}
+			last = c.total
+			sample := Sample{
+				Offset: math.Round(now.Sub(c.start).Seconds()*1000) / 1000,
+				Bytes:  n,
+				Speed:  math.Round(c.toMbps(float64(n)/now.Sub(lastTime).Seconds())*100) / 100,
+			}
+			c.samples = append(c.samples, sample)
+			c.lock.Unlock()
+			lastTime = now
+
+			if c.onSample != nil {
+				c.onSample(sample, last)
+			}
+		}
+	}
+}
+
+// TrimmedMbps returns the average mbits/second excluding the bytes moved within `warmup`, together with the actual
+// excluded duration, which is aligned to the end of a sampling interval
+func (c *BytesCounter) TrimmedMbps(warmup time.Duration) (float64, time.Duration) {
+	if warmup <= 0 {
+		return c.AvgMbps(), 0
+	}
+
+	var excluded uint64
+	for _, s := range c.Samples() {
+		excluded += s.Bytes
+		if s.Offset >= warmup.Seconds() {
+			offset := time.Duration(s.Offset * float64(time.Second))
+			if elapsed := c.Elapsed() - offset; elapsed > 0 {
+				return c.toMbps(float64(c.Total()-excluded) / elapsed.Seconds()), offset
+			}
+			break
+		}
+	}
+
+	log.Debugf("Warm-up period %s exceeds the test duration, nothing excluded", warmup)
+	return c.AvgMbps(), 0
+}
+
+// DetectWarmup returns the duration after which the throughput stops rising, that is when the average of a one second
+// window is no more than 10% above the previous one. The result never exceeds half of the test duration
+func (c *BytesCounter) DetectWarmup() time.Duration {
+	if c.interval <= 0 {
+		return 0
+	}
+
+	window := int(time.Second / c.interval)
+	if window < 3 {
+		window = 3
+	}
+
+	samples := c.Samples()
+	if len(samples) < window*2 {
+		log.Debugf("Not enough samples to detect the warm-up period")
+		return 0
+	}
+
+	limit := len(samples) / 2
+	for i := window; i+window <= len(samples) && i <= limit; i++ {
+		prev, next := getAvg(speeds(samples[i-window:i])), getAvg(speeds(samples[i:i+window]))
+		if prev > 0 && next <= prev*1.1 {
+			return time.Duration(samples[i-1].Offset * float64(time.Second))
+		}
+	}
+
+	return time.Duration(samples[limit].Offset * float64(time.Second))
+}
+
+// Result returns the outcome of the test, with the bytes moved within the warm-up period excluded from the speed and
+// statistics. The reported speed is either the mean or the 90th percentile of the samples depending on `metric`
+func (c *BytesCounter) Result(warmup time.Duration, auto bool, metric SpeedMetric) *TransferResult {
+	if auto {
+		warmup = c.DetectWarmup()
+	}
+
+	res := &TransferResult{RawMbps: c.AvgMbps(), Bytes: c.Total(), Elapsed: c.Elapsed(), Samples: c.Samples()}
+	res.Mbps, res.Warmup = c.TrimmedMbps(warmup)
+	if res.Warmup > 0 {
+		log.Debugf("Excluded the first %s as warm-up: %.2f Mbps (raw %.2f Mbps)", res.Warmup, res.Mbps, res.RawMbps)
+	}
+
+	var vals []float64
+	for _, s := range res.Samples {
+		if s.Offset > res.Warmup.Seconds() {
+			vals = append(vals, s.Speed)
+		}
+	}
+	res.Stats = getSpeedStats(vals)
+	res.Stats.Mean = res.Mbps
+
+	if metric == MetricP90 && len(vals) > 0 {
+		res.Mbps = res.Stats.P90
+	}
+
+	return res
+}
+
+// StatsHumanize returns the peak, percentiles and standard deviation of the speeds in a human-readable form
+func (c *BytesCounter) StatsHumanize(stats SpeedStats, useBytes bool) string {
+	format := func(mbps float64) string {
+		if useBytes {
+			return c.Humanize(mbps)
+		}
+		return fmt.Sprintf("%.2f Mbps", mbps)
+	}
+
+	return fmt.Sprintf("peak: %s, p10: %s, p50: %s, p90: %s, stddev: %s",
+		format(stats.Peak), format(stats.P10), format(stats.P50), format(stats.P90), format(stats.StdDev))
+}
+
+// Samples returns the throughput samples recorded so far
+func (c *BytesCounter) Samples() []Sample {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]Sample(nil), c.samples...)
 }
 
 // Total returns the total bytes read/written
 func (c *BytesCounter) Total() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	return c.total
 }
 
 // CurrentSpeed returns the current bytes/second
 func (c *BytesCounter) CurrentSpeed() float64 {
-	return float64(c.total) / time.Since(c.start).Seconds()
+	return c.AvgBytes()
 }
 
 // SeekWrapper is a wrapper around io.Reader to give it a noop io.Seeker interface
@@ -179,6 +647,15 @@ func getAvg(vals []float64) float64 {
 	return total / float64(len(vals))
 }
 
+// speeds returns the speed of each sample
+func speeds(samples []Sample) []float64 {
+	vals := make([]float64, len(samples))
+	for i, s := range samples {
+		vals[i] = s.Speed
+	}
+	return vals
+}
+
 // getRandomData returns an `length` sized array of random bytes
 func getRandomData(length int) []byte {
 	data := make([]byte, length)
@@ -0,0 +1,247 @@
+package defs
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// stoppedCounter returns a counter stopped after the given samples of Mbps, taken every interval
+func stoppedCounter(interval time.Duration, mbps ...float64) *BytesCounter {
+	c := NewCounter()
+	c.SetInterval(interval)
+	c.start = time.Unix(0, 0)
+	for i, speed := range mbps {
+		n := uint64(speed * 125000 * interval.Seconds())
+		c.total += n
+		c.samples = append(c.samples, Sample{
+			Offset: (time.Duration(i+1) * interval).Seconds(),
+			Bytes:  n,
+			Speed:  speed,
+		})
+	}
+	c.end = c.start.Add(time.Duration(len(mbps)) * interval)
+	return c
+}
+
+func TestTrimmedMbps(t *testing.T) {
+	tests := []struct {
+		name         string
+		mbps         []float64
+		warmup       time.Duration
+		wantMbps     float64
+		wantExcluded time.Duration
+	}{
+		{"no warm-up", []float64{10, 20, 30, 40}, 0, 25, 0},
+		{"aligned warm-up", []float64{10, 10, 50, 50}, 2 * time.Second, 50, 2 * time.Second},
+		{"warm-up within an interval", []float64{10, 10, 50, 50}, 1500 * time.Millisecond, 50, 2 * time.Second},
+		{"warm-up exceeding the test", []float64{10, 20, 30, 40}, 10 * time.Second, 25, 0},
+		{"warm-up of the whole test", []float64{10, 20, 30, 40}, 4 * time.Second, 25, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := stoppedCounter(time.Second, tt.mbps...)
+			mbps, excluded := c.TrimmedMbps(tt.warmup)
+			if math.Abs(mbps-tt.wantMbps) > 0.01 || excluded != tt.wantExcluded {
+				t.Errorf("TrimmedMbps(%s) = %.2f, %s; want %.2f, %s", tt.warmup, mbps, excluded, tt.wantMbps, tt.wantExcluded)
+			}
+		})
+	}
+}
+
+func TestDetectWarmup(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		mbps     []float64
+		want     time.Duration
+	}{
+		{"sampling disabled", 0, nil, 0},
+		{"not enough samples", time.Second, []float64{10, 20, 30, 40, 50}, 0},
+		{"flat from the start", time.Second, []float64{50, 50, 50, 50, 50, 50, 50, 50}, 3 * time.Second},
+		{"ramp then plateau", time.Second, []float64{10, 20, 40, 60, 80, 80, 80, 80, 80, 80, 80, 80}, 6 * time.Second},
+		{"rising throughout", time.Second, []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, 6 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := stoppedCounter(time.Second, tt.mbps...)
+			c.SetInterval(tt.interval)
+			if got := c.DetectWarmup(); got != tt.want {
+				t.Errorf("DetectWarmup() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// isClosed reports whether the channel is closed
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestLimitUpload(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit uint64
+		reads int
+		want  uint64
+		full  bool
+	}{
+		{"unlimited", 0, 3, 3 * 1024, false},
+		{"below the limit", 4096, 3, 3 * 1024, false},
+		{"exact limit", 2048, 3, 2048, true},
+		{"limit within a read", 2500, 4, 2500, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCounter()
+			c.SetUploadSize(1)
+			c.GenerateBlob()
+			c.SetLimit(tt.limit)
+
+			buf := make([]byte, 1024)
+			for i := 0; i < tt.reads; i++ {
+				if _, err := c.Read(buf); err == io.EOF {
+					break
+				}
+			}
+			if got := c.Total(); got != tt.want {
+				t.Errorf("Total() = %d, want %d", got, tt.want)
+			}
+			if tt.limit > 0 && isClosed(c.Full()) != tt.full {
+				t.Errorf("Full() closed = %t, want %t", !tt.full, tt.full)
+			}
+		})
+	}
+}
+
+func TestLimitDownload(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit uint64
+		size  int
+		want  uint64
+		full  bool
+	}{
+		{"unlimited", 0, 5000, 5000, false},
+		{"below the limit", 8000, 5000, 5000, false},
+		{"limit reached", 3000, 5000, 3000, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCounter()
+			c.SetLimit(tt.limit)
+
+			n, err := io.Copy(io.Discard, c.Wrap(bytes.NewReader(make([]byte, tt.size)), c.newConn()))
+			if err != nil {
+				t.Fatalf("io.Copy() error = %v", err)
+			}
+			if uint64(n) != tt.want || c.Total() != tt.want {
+				t.Errorf("read %d bytes, Total() = %d, want %d", n, c.Total(), tt.want)
+			}
+			if conns := c.Conns(); len(conns) != 1 || conns[0].Bytes != tt.want {
+				t.Errorf("Conns() = %+v, want %d bytes on a single connection", conns, tt.want)
+			}
+			if tt.limit > 0 && isClosed(c.Full()) != tt.full {
+				t.Errorf("Full() closed = %t, want %t", !tt.full, tt.full)
+			}
+		})
+	}
+}
+
+func TestDiscardSource(t *testing.T) {
+	c := NewCounter()
+	c.SetUploadSize(1)
+	c.GenerateBlob()
+	conn := c.newConn()
+
+	buf := make([]byte, 1024)
+	failed := c.source(conn)
+	for i := 0; i < 3; i++ {
+		failed.Read(buf)
+	}
+	failed.discard()
+	if c.Total() != 0 || conn.Bytes != 0 {
+		t.Fatalf("Total() = %d, connection bytes = %d after discarding, want 0", c.Total(), conn.Bytes)
+	}
+	if n, err := failed.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read() after discarding = %d, %v, want 0, EOF", n, err)
+	}
+
+	retry := c.source(conn)
+	retry.Read(buf)
+	if c.Total() != 1024 || conn.Bytes != 1024 {
+		t.Errorf("Total() = %d, connection bytes = %d after the retry, want 1024", c.Total(), conn.Bytes)
+	}
+}
+
+func TestSamples(t *testing.T) {
+	const interval = 50 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		mebi     bool
+		// wantMbps is the speed of the first sample, which has every byte
+		wantMbps float64
+	}{
+		{"sampling disabled", 0, false, 0},
+		{"megabits", interval, false, 20},
+		{"mebibits", interval, true, 19.07},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCounter()
+			c.SetInterval(tt.interval)
+			c.SetMebi(tt.mebi)
+			c.Start()
+			io.Copy(io.Discard, c.Wrap(bytes.NewReader(make([]byte, 125000)), nil))
+			time.Sleep(3*interval + interval/2)
+			c.Stop()
+
+			samples := c.Samples()
+			time.Sleep(interval)
+			if len(c.Samples()) != len(samples) {
+				t.Errorf("got %d samples after Stop(), want %d", len(c.Samples()), len(samples))
+			}
+			if tt.interval == 0 {
+				if len(samples) != 0 {
+					t.Errorf("Samples() = %+v, want none", samples)
+				}
+				return
+			}
+
+			if len(samples) < 3 {
+				t.Fatalf("Samples() = %+v, want at least 3", samples)
+			}
+			var total uint64
+			for i, sample := range samples {
+				total += sample.Bytes
+				if i > 0 && sample.Offset <= samples[i-1].Offset {
+					t.Errorf("sample %d at %.3f s, after the previous one at %.3f s", i, sample.Offset, samples[i-1].Offset)
+				}
+				if i > 0 && (sample.Bytes != 0 || sample.Speed != 0) {
+					t.Errorf("sample %d = %+v, want no throughput", i, sample)
+				}
+			}
+			if total != 125000 {
+				t.Errorf("samples have %d bytes, want 125000", total)
+			}
+			// the ticks can be late, which lowers the speed
+			if speed := samples[0].Speed; speed > tt.wantMbps*1.05 || speed < tt.wantMbps/2 {
+				t.Errorf("first sample speed = %.2f Mbps, want about %.2f Mbps", speed, tt.wantMbps)
+			}
+		})
+	}
+}
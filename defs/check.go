@@ -0,0 +1,53 @@
+package defs
+
+// Exit codes of the runs breaching a threshold, or missing the result of a server to check. If several checks fail, the
+// code of the first one in this order is used
+const (
+	ExitDownload = 10 + iota
+	ExitUpload
+	ExitPing
+	ExitJitter
+	ExitLoss
+	ExitDownloadRegression
+	ExitUploadRegression
+	ExitNoResult
+)
+
+// Check represents the outcome of a threshold check of a result
+type Check struct {
+	Name   string `json:"name"`
+	Server string `json:"server"`
+	Stack  string `json:"stack"`
+	// Value is the measured value, and Threshold the limit it is checked against, which is derived from Baseline and
+	// the allowed percentage for the regression checks
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Baseline  float64 `json:"baseline,omitempty"`
+	Passed    bool    `json:"passed"`
+	ExitCode  int     `json:"exit_code"`
+}
+
+// CheckSummary represents the outcome of all threshold checks of a run
+type CheckSummary struct {
+	Passed bool `json:"passed"`
+	// ExitCode is the exit code of the first failed check, 0 if all of them passed
+	ExitCode int      `json:"exit_code"`
+	Failed   []string `json:"failed"`
+	Checks   []Check  `json:"checks"`
+}
+
+// NewCheckSummary summarises the checks, with the failed ones named by check and server
+func NewCheckSummary(checks []Check) CheckSummary {
+	summary := CheckSummary{Passed: true, Failed: []string{}, Checks: checks}
+	for _, check := range checks {
+		if check.Passed {
+			continue
+		}
+		summary.Passed = false
+		if summary.ExitCode == 0 || check.ExitCode < summary.ExitCode {
+			summary.ExitCode = check.ExitCode
+		}
+		summary.Failed = append(summary.Failed, check.Name+":"+check.Server)
+	}
+	return summary
+}
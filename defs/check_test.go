@@ -0,0 +1,38 @@
+package defs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCheckSummary(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantPassed bool
+		wantCode   int
+		wantFailed []string
+	}{
+		{"no checks", nil, true, 0, []string{}},
+		{"all passed", []Check{{Name: "ping", Server: "1", Passed: true, ExitCode: ExitPing}}, true, 0, []string{}},
+		{"one failed", []Check{
+			{Name: "ping", Server: "1", Passed: true, ExitCode: ExitPing},
+			{Name: "upload", Server: "1", ExitCode: ExitUpload},
+		}, false, ExitUpload, []string{"upload:1"}},
+		{"lowest code wins", []Check{
+			{Name: "result", Server: "2", ExitCode: ExitNoResult},
+			{Name: "loss", Server: "1", ExitCode: ExitLoss},
+			{Name: "download", Server: "1", ExitCode: ExitDownload},
+		}, false, ExitDownload, []string{"result:2", "loss:1", "download:1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewCheckSummary(tt.checks)
+			if got.Passed != tt.wantPassed || got.ExitCode != tt.wantCode || !reflect.DeepEqual(got.Failed, tt.wantFailed) {
+				t.Errorf("NewCheckSummary() = %t, %d, %v; want %t, %d, %v", got.Passed, got.ExitCode, got.Failed,
+					tt.wantPassed, tt.wantCode, tt.wantFailed)
+			}
+		})
+	}
+}
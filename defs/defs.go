@@ -2,6 +2,7 @@ package defs
 
 import (
 	"fmt"
+	"net"
 	"runtime"
 )
 
@@ -14,14 +15,90 @@ const (
 	StackDual
 )
 
+// String returns the name of the stack as shown in the results
+func (s Stack) String() string {
+	switch s {
+	case StackIPv4:
+		return "ipv4"
+	case StackIPv6:
+		return "ipv6"
+	case StackDual:
+		return "dual"
+	default:
+		return "all"
+	}
+}
+
+// StackOf returns the IP version of the given address, or StackAll if it is not an IP address
+func StackOf(ip string) Stack {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return StackAll
+	case addr.To4() != nil:
+		return StackIPv4
+	default:
+		return StackIPv6
+	}
+}
+
+// ExitInterrupted is the exit code of a run interrupted by SIGINT/SIGTERM, whose results are partial
+const ExitInterrupted = 130
+
 type PingType uint8
 
 const (
 	ICMP PingType = iota
 	UDP
 	HTTP
+	TCP
 )
 
+// String returns the name of the ping type, as given to --ping
+func (p PingType) String() string {
+	switch p {
+	case ICMP:
+		return "icmp"
+	case UDP:
+		return "udp"
+	case HTTP:
+		return "http"
+	case TCP:
+		return "tcp"
+	default:
+		return "unknown"
+	}
+}
+
+type SpeedMetric uint8
+
+const (
+	MetricMean SpeedMetric = iota
+	MetricP90
+)
+
+type JitterAlgorithm uint8
+
+const (
+	JitterLegacy JitterAlgorithm = iota
+	JitterRFC3550
+	JitterMSD
+)
+
+// ParseJitterAlgorithm returns the jitter algorithm of the given name
+func ParseJitterAlgorithm(name string) (JitterAlgorithm, error) {
+	switch name {
+	case "legacy":
+		return JitterLegacy, nil
+	case "rfc3550":
+		return JitterRFC3550, nil
+	case "msd":
+		return JitterMSD, nil
+	default:
+		return JitterLegacy, fmt.Errorf("unknown jitter algorithm: %s", name)
+	}
+}
+
 var (
 	BuildDate   string
 	ProgName    string
@@ -0,0 +1,109 @@
+package defs
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event types of the JSON lines event stream
+const (
+	EventServerSelected   = "server-selected"
+	EventPingSample       = "ping-sample"
+	EventDownloadProgress = "download-progress"
+	EventUploadProgress   = "upload-progress"
+	EventResult           = "result"
+	EventError            = "error"
+	EventDone             = "done"
+)
+
+// Event represents a line of the JSON lines event stream
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// ServerEvent is the data of a server-selected event
+type ServerEvent struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	Province string `json:"province"`
+	City     string `json:"city"`
+	ISP      string `json:"isp"`
+	Stack    string `json:"stack"`
+	Type     string `json:"type"`
+}
+
+// PingEvent is the data of a ping-sample event
+type PingEvent struct {
+	Server string  `json:"server"`
+	Seq    int     `json:"seq"`
+	RTT    float64 `json:"rtt"`
+}
+
+// ProgressEvent is the data of a download-progress or upload-progress event
+type ProgressEvent struct {
+	Server  string  `json:"server"`
+	Elapsed float64 `json:"elapsed"`
+	// Speed is the throughput within the last sampling interval, and Average the one since the test started, in Mbps
+	Speed   float64 `json:"speed"`
+	Average float64 `json:"average"`
+	Bytes   uint64  `json:"bytes"`
+}
+
+// ErrorEvent is the data of an error event
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
+
+// DoneEvent is the data of the done event, which ends the stream
+type DoneEvent struct {
+	Results     int  `json:"results"`
+	Interrupted bool `json:"interrupted"`
+}
+
+// EventWriter writes the events of a test run as JSON lines. A nil EventWriter discards them, so that it can be passed
+// around unconditionally
+type EventWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewEventWriter creates a new EventWriter writing to w
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{w: w}
+}
+
+// Emit writes an event of the given type with the current time. Each line is written at once, so that they can be sent
+// as separate datagrams
+func (e *EventWriter) Emit(event string, data interface{}) {
+	if e == nil {
+		return
+	}
+
+	b, err := json.Marshal(Event{Type: event, Timestamp: time.Now(), Data: data})
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.w.Write(b)
+}
+
+// Levels implements log.Hook, the errors logged are emitted as error events
+func (e *EventWriter) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel}
+}
+
+// Fire implements log.Hook
+func (e *EventWriter) Fire(entry *log.Entry) error {
+	e.Emit(EventError, ErrorEvent{Message: entry.Message})
+	return nil
+}
@@ -0,0 +1,108 @@
+package defs
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// influxMeasurement is the measurement of the InfluxDB lines, and the prefix of the Graphite metrics
+const influxMeasurement = "taierspeed"
+
+// WriteInflux writes the results in InfluxDB line protocol, one line per result, tagged by the server ID, name, ISP,
+// province and stack. Each line is written at once, so that they can be sent as separate datagrams
+func WriteInflux(w io.Writer, results []Result) error {
+	escapeTag := strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", ``)
+
+	for _, r := range results {
+		var b strings.Builder
+		b.WriteString(influxMeasurement)
+		for _, tag := range r.tags() {
+			if tag[1] != "" {
+				fmt.Fprintf(&b, ",%s=%s", tag[0], escapeTag.Replace(tag[1]))
+			}
+		}
+
+		b.WriteByte(' ')
+		for i, field := range r.fields() {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=%s", field.name, field)
+			if field.integer {
+				b.WriteByte('i')
+			}
+		}
+		fmt.Fprintf(&b, " %d\n", r.Timestamp.UnixNano())
+
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteGraphite writes the results in Graphite plaintext protocol with tags, one line per field of each result. Each
+// line is written at once, so that they can be sent as separate datagrams
+func WriteGraphite(w io.Writer, results []Result) error {
+	// ';' and '~' are not allowed in tag values, and spaces would break the line
+	escapeTag := strings.NewReplacer(`;`, `_`, `~`, `_`, ` `, `_`, "\n", ``)
+
+	for _, r := range results {
+		var tags strings.Builder
+		for _, tag := range r.tags() {
+			if tag[1] != "" {
+				fmt.Fprintf(&tags, ";%s=%s", tag[0], escapeTag.Replace(tag[1]))
+			}
+		}
+
+		for _, field := range r.fields() {
+			line := fmt.Sprintf("%s.%s%s %s %d\n", influxMeasurement, field.name, tags.String(), field, r.Timestamp.Unix())
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resultField represents a field of a result in the time series formats
+type resultField struct {
+	name    string
+	value   float64
+	integer bool
+}
+
+// String returns the value of the field without exponent
+func (f resultField) String() string {
+	return strconv.FormatFloat(f.value, 'f', -1, 64)
+}
+
+// tags returns the tags of a result in the time series formats
+func (r *Result) tags() [][2]string {
+	return [][2]string{
+		{"id", r.ID},
+		{"name", r.Name},
+		{"isp", r.ISP},
+		{"province", r.Province},
+		{"stack", r.Stack},
+	}
+}
+
+// fields returns the fields of a result in the time series formats
+func (r *Result) fields() []resultField {
+	return []resultField{
+		{"ping", r.Ping, false},
+		{"jitter", r.Jitter, false},
+		{"packet_loss", r.PacketLoss, false},
+		{"download", r.Download, false},
+		{"upload", r.Upload, false},
+		{"download_latency", r.DownloadLatency, false},
+		{"upload_latency", r.UploadLatency, false},
+		{"bytes_received", float64(r.BytesReceived), true},
+		{"bytes_sent", float64(r.BytesSent), true},
+	}
+}
@@ -0,0 +1,77 @@
+package defs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteInflux(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		result Result
+		want   string
+	}{
+		{
+			name:   "plain tags",
+			result: Result{ID: "1", Name: "Beijing", ISP: "联通", Province: "北京", Stack: "ipv4", Ping: 1.5, Download: 100, Upload: 50, BytesReceived: 1000, BytesSent: 500, Timestamp: ts},
+			want: "taierspeed,id=1,name=Beijing,isp=联通,province=北京,stack=ipv4 ping=1.5,jitter=0,packet_loss=0,download=100," +
+				"upload=50,download_latency=0,upload_latency=0,bytes_received=1000i,bytes_sent=500i 1700000000000000000\n",
+		},
+		{
+			name:   "escaped tags and empty tags omitted",
+			result: Result{ID: "a=b", Name: "A, B\nC", Timestamp: ts},
+			want: `taierspeed,id=a\=b,name=A\,\ BC ping=0,jitter=0,packet_loss=0,download=0,upload=0,download_latency=0,` +
+				"upload_latency=0,bytes_received=0i,bytes_sent=0i 1700000000000000000\n",
+		},
+		{
+			name:   "no exponent",
+			result: Result{ID: "1", Download: 12345678.9, Timestamp: ts},
+			want: "taierspeed,id=1 ping=0,jitter=0,packet_loss=0,download=12345678.9,upload=0,download_latency=0," +
+				"upload_latency=0,bytes_received=0i,bytes_sent=0i 1700000000000000000\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := WriteInflux(&b, []Result{tt.result}); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("WriteInflux() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteGraphite(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		result    Result
+		wantFirst string
+	}{
+		{"plain tags", Result{ID: "1", Name: "Beijing", ISP: "联通", Stack: "ipv4", Ping: 1.5, Timestamp: ts},
+			"taierspeed.ping;id=1;name=Beijing;isp=联通;stack=ipv4 1.5 1700000000\n"},
+		{"escaped tags", Result{ID: "1", Name: "A;B~C D\nE", Timestamp: ts},
+			"taierspeed.ping;id=1;name=A_B_C_DE 0 1700000000\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := WriteGraphite(&b, []Result{tt.result}); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.SplitAfter(b.String(), "\n")
+			if len(lines) != len(tt.result.fields())+1 || lines[len(lines)-1] != "" {
+				t.Fatalf("WriteGraphite() wrote %d lines, want one per field", len(lines)-1)
+			}
+			if lines[0] != tt.wantFirst {
+				t.Errorf("WriteGraphite() first line = %q, want %q", lines[0], tt.wantFirst)
+			}
+		})
+	}
+}
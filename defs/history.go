@@ -0,0 +1,187 @@
+package defs
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HistoryFileName is the name of the history file under the history directory
+const HistoryFileName = "history.jsonl"
+
+// HistoryEntry represents a test result recorded in the history, together with the client and the run parameters
+type HistoryEntry struct {
+	Result Result          `json:"result"`
+	Client *IPInfoResponse `json:"client,omitempty"`
+	Params RunParams       `json:"params"`
+}
+
+// HistoryFilter selects the history entries by server, ISP and time range. Empty fields match every entry
+type HistoryFilter struct {
+	Servers []string
+	ISP     string
+	Since   time.Time
+	Until   time.Time
+}
+
+// HistoryAggregate represents the statistics of the history entries within a period
+type HistoryAggregate struct {
+	Period       string  `json:"period" csv:"Period"`
+	Tests        int     `json:"tests" csv:"Tests"`
+	PingMean     float64 `json:"ping_mean" csv:"PingMean"`
+	PingMin      float64 `json:"ping_min" csv:"PingMin"`
+	PingMax      float64 `json:"ping_max" csv:"PingMax"`
+	DownloadMean float64 `json:"download_mean" csv:"DownloadMean"`
+	DownloadMin  float64 `json:"download_min" csv:"DownloadMin"`
+	DownloadMax  float64 `json:"download_max" csv:"DownloadMax"`
+	UploadMean   float64 `json:"upload_mean" csv:"UploadMean"`
+	UploadMin    float64 `json:"upload_min" csv:"UploadMin"`
+	UploadMax    float64 `json:"upload_max" csv:"UploadMax"`
+}
+
+// AppendHistory appends the entries to the history file at path, one JSON line each, creating it if needed
+func AppendHistory(path string, entries []HistoryEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	// the lines are written at once, so that the runs appending at the same time are not interleaved
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadHistory reads the entries of the history file at path matching the filter, in the order they were recorded.
+// Lines which cannot be parsed, e.g. a partially written one, are skipped
+func ReadHistory(path string, filter HistoryFilter) ([]HistoryEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Debugf("Skipping invalid history line %d: %s", line, err)
+			continue
+		}
+		if filter.match(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// match checks whether the entry is selected by the filter
+func (f HistoryFilter) match(entry *HistoryEntry) bool {
+	if len(f.Servers) > 0 {
+		found := false
+		for _, id := range f.Servers {
+			if id == entry.Result.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.ISP != "" && !strings.Contains(entry.Result.ISP, f.ISP) {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Result.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Result.Timestamp.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AggregateHistory groups the entries by the period their local timestamp formatted with layout falls into, e.g.
+// time.DateOnly for days, and returns the statistics of each period in chronological order. Download and upload speeds
+// of 0, i.e. not tested, are left out of the statistics
+func AggregateHistory(entries []HistoryEntry, layout string) []HistoryAggregate {
+	type values struct {
+		ping, download, upload []float64
+	}
+	periods := make(map[string]*values)
+	var keys []string
+	for _, entry := range entries {
+		key := entry.Result.Timestamp.Local().Format(layout)
+		v, ok := periods[key]
+		if !ok {
+			v = &values{}
+			periods[key] = v
+			keys = append(keys, key)
+		}
+		v.ping = append(v.ping, entry.Result.Ping)
+		if entry.Result.Download > 0 {
+			v.download = append(v.download, entry.Result.Download)
+		}
+		if entry.Result.Upload > 0 {
+			v.upload = append(v.upload, entry.Result.Upload)
+		}
+	}
+	// the layouts of days and hours sort chronologically
+	sort.Strings(keys)
+
+	aggregates := make([]HistoryAggregate, 0, len(keys))
+	for _, key := range keys {
+		v := periods[key]
+		agg := HistoryAggregate{Period: key, Tests: len(v.ping)}
+		agg.PingMean, agg.PingMin, agg.PingMax = meanMinMax(v.ping)
+		agg.DownloadMean, agg.DownloadMin, agg.DownloadMax = meanMinMax(v.download)
+		agg.UploadMean, agg.UploadMin, agg.UploadMax = meanMinMax(v.upload)
+		aggregates = append(aggregates, agg)
+	}
+
+	return aggregates
+}
+
+// meanMinMax returns the mean, minimum and maximum of the values, rounded to 2 decimals, or 0s if there is none
+func meanMinMax(vals []float64) (float64, float64, float64) {
+	if len(vals) == 0 {
+		return 0, 0, 0
+	}
+
+	min, max := vals[0], vals[0]
+	for _, v := range vals {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	return math.Round(getAvg(vals)*100) / 100, math.Round(min*100) / 100, math.Round(max*100) / 100
+}
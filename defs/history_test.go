@@ -0,0 +1,135 @@
+package defs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// historyEntry returns an entry of the server id tested at the given local time
+func historyEntry(id, isp string, at time.Time, ping, download, upload float64) HistoryEntry {
+	return HistoryEntry{Result: Result{ID: id, ISP: isp, Timestamp: at, Ping: ping, Download: download, Upload: upload}}
+}
+
+func TestHistoryFilterMatch(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	entry := historyEntry("1", "联通", at, 10, 100, 50)
+
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   bool
+	}{
+		{"empty", HistoryFilter{}, true},
+		{"server", HistoryFilter{Servers: []string{"2", "1"}}, true},
+		{"other server", HistoryFilter{Servers: []string{"2"}}, false},
+		{"ISP", HistoryFilter{ISP: "联通"}, true},
+		{"other ISP", HistoryFilter{ISP: "电信"}, false},
+		{"since inclusive", HistoryFilter{Since: at}, true},
+		{"since later", HistoryFilter{Since: at.Add(time.Second)}, false},
+		{"until exclusive", HistoryFilter{Until: at}, false},
+		{"until later", HistoryFilter{Until: at.Add(time.Second)}, true},
+		{"all matching", HistoryFilter{Servers: []string{"1"}, ISP: "联通", Since: at.Add(-time.Hour), Until: at.Add(time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(&entry); got != tt.want {
+				t.Errorf("match() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", HistoryFileName)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	if err := AppendHistory(path, []HistoryEntry{
+		historyEntry("1", "联通", at, 10, 100, 50),
+		historyEntry("2", "电信", at.Add(time.Hour), 20, 200, 100),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a partially written line is skipped
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"result": {"id": "3"` + "\n")
+	f.Close()
+
+	if err := AppendHistory(path, []HistoryEntry{historyEntry("1", "联通", at.Add(2*time.Hour), 30, 300, 150)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   []float64
+	}{
+		{"all in order", HistoryFilter{}, []float64{10, 20, 30}},
+		{"by server", HistoryFilter{Servers: []string{"1"}}, []float64{10, 30}},
+		{"by time", HistoryFilter{Since: at.Add(time.Hour)}, []float64{20, 30}},
+		{"none", HistoryFilter{ISP: "移动"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ReadHistory(path, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []float64
+			for _, e := range entries {
+				got = append(got, e.Result.Ping)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadHistory() pings = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ReadHistory(filepath.Join(t.TempDir(), HistoryFileName), HistoryFilter{}); !os.IsNotExist(err) {
+		t.Errorf("ReadHistory() of a missing file error = %v, want not exist", err)
+	}
+}
+
+func TestAggregateHistory(t *testing.T) {
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	entries := []HistoryEntry{
+		historyEntry("1", "", day.AddDate(0, 0, 1), 30, 300, 0),
+		historyEntry("1", "", day, 10, 100, 40),
+		historyEntry("1", "", day.Add(time.Hour), 20, 0, 60),
+	}
+
+	tests := []struct {
+		name    string
+		entries []HistoryEntry
+		layout  string
+		want    []HistoryAggregate
+	}{
+		{"by day", entries, time.DateOnly, []HistoryAggregate{
+			{Period: "2024-05-01", Tests: 2, PingMean: 15, PingMin: 10, PingMax: 20, DownloadMean: 100, DownloadMin: 100, DownloadMax: 100,
+				UploadMean: 50, UploadMin: 40, UploadMax: 60},
+			{Period: "2024-05-02", Tests: 1, PingMean: 30, PingMin: 30, PingMax: 30, DownloadMean: 300, DownloadMin: 300, DownloadMax: 300},
+		}},
+		{"by hour", entries, "2006-01-02 15", []HistoryAggregate{
+			{Period: "2024-05-01 10", Tests: 1, PingMean: 10, PingMin: 10, PingMax: 10, DownloadMean: 100, DownloadMin: 100, DownloadMax: 100,
+				UploadMean: 40, UploadMin: 40, UploadMax: 40},
+			{Period: "2024-05-01 11", Tests: 1, PingMean: 20, PingMin: 20, PingMax: 20, UploadMean: 60, UploadMin: 60, UploadMax: 60},
+			{Period: "2024-05-02 10", Tests: 1, PingMean: 30, PingMin: 30, PingMax: 30, DownloadMean: 300, DownloadMin: 300, DownloadMax: 300},
+		}},
+		{"nothing", nil, time.DateOnly, []HistoryAggregate{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AggregateHistory(tt.entries, tt.layout); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AggregateHistory() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
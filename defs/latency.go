@@ -0,0 +1,193 @@
+package defs
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/prometheus-community/pro-bing"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadedPingInterval is the interval between latency probes sent during transfer tests
+const loadedPingInterval = 250 * time.Millisecond
+
+// ProbeLatency keeps pinging the server with its ping type until ctx is done, and returns the average latency and
+// jitter in milliseconds. It is meant to run alongside a transfer test to measure the latency under load
+func (s *Server) ProbeLatency(ctx context.Context, srcIp, network string) (float64, float64) {
+	var pings []float64
+	switch s.PingType {
+	case HTTP:
+		pings = s.probeHTTP(ctx)
+	case TCP:
+		pings = s.probeTCP(ctx)
+	default:
+		p := probing.New(s.Target)
+		if s.PingType == ICMP {
+			p.SetPrivileged(true)
+		}
+		p.SetNetwork(network)
+		p.Interval = loadedPingInterval
+		if srcIp != "" {
+			p.Source = srcIp
+		}
+		p.OnRecv = func(pkt *probing.Packet) {
+			pings = append(pings, toMilliseconds(pkt.Rtt))
+		}
+		if err := p.RunWithContext(ctx); err != nil && ctx.Err() == nil {
+			log.Debugf("Failed to ping target host under load: %s", err)
+		}
+	}
+
+	if len(pings) == 0 {
+		log.Debugf("No pings returned under load for server %s (%s)", s.Name, s.ID)
+		return 0, 0
+	}
+
+	return getAvg(pings), getJitter(pings, s.JitterAlgorithm)
+}
+
+// probeHTTP keeps accessing the ping URL until ctx is done, and returns the round trip times in milliseconds
+func (s *Server) probeHTTP(ctx context.Context) []float64 {
+	var pings []float64
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.PingURL().String(), nil)
+	if err != nil {
+		log.Debugf("Failed when creating HTTP request: %s", err)
+		return nil
+	}
+
+	if s.Host != "" {
+		req.Host = s.GetHost()
+	}
+	req.Header.Set("User-Agent", AndroidUA)
+
+	ticker := time.NewTicker(loadedPingInterval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			pings = append(pings, toMilliseconds(time.Since(start)))
+		}
+
+		select {
+		case <-ctx.Done():
+			// discard first result due to handshake overhead
+			if len(pings) > 1 {
+				pings = pings[1:]
+			}
+			return pings
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeTCP keeps timing TCP handshakes to the server until ctx is done, and returns the round trip times in milliseconds
+func (s *Server) probeTCP(ctx context.Context) []float64 {
+	var pings []float64
+
+	ticker := time.NewTicker(loadedPingInterval)
+	defer ticker.Stop()
+
+	for {
+		if rtt, err := s.tcpHandshake(ctx); err == nil {
+			pings = append(pings, rtt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return pings
+		case <-ticker.C:
+		}
+	}
+}
+
+// BufferbloatGrade grades the increase of latency under load over the idle latency, A+ being the best and F the worst
+func BufferbloatGrade(idle, download, upload float64) string {
+	if download == 0 && upload == 0 {
+		return ""
+	}
+
+	increase := math.Max(download-idle, upload-idle)
+	switch {
+	case increase < 5:
+		return "A+"
+	case increase < 30:
+		return "A"
+	case increase < 60:
+		return "B"
+	case increase < 200:
+		return "C"
+	case increase < 400:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// getJitter returns the jitter of a series of round trip times calculated by the given algorithm
+func getJitter(pings []float64, alg JitterAlgorithm) float64 {
+	switch alg {
+	case JitterRFC3550:
+		return getInterarrivalJitter(pings)
+	case JitterMSD:
+		return getMeanSuccessiveDiff(pings)
+	default:
+		return getSmoothedJitter(pings)
+	}
+}
+
+// getSmoothedJitter returns the jitter of a series of round trip times, smoothed by weighting the previous value
+func getSmoothedJitter(pings []float64) float64 {
+	var lastPing, jitter float64
+	for idx, p := range pings {
+		if idx != 0 {
+			instJitter := math.Abs(lastPing - p)
+			if idx > 1 {
+				if jitter > instJitter {
+					jitter = jitter*0.7 + instJitter*0.3
+				} else {
+					jitter = instJitter*0.2 + jitter*0.8
+				}
+			}
+		}
+		lastPing = p
+	}
+
+	return jitter
+}
+
+// getInterarrivalJitter returns the interarrival jitter of a series of round trip times as defined in RFC 3550
+// section 6.4.1, a running estimate of the successive differences with a gain of 1/16
+func getInterarrivalJitter(pings []float64) float64 {
+	var jitter float64
+	for idx := 1; idx < len(pings); idx++ {
+		jitter += (math.Abs(pings[idx]-pings[idx-1]) - jitter) / 16
+	}
+
+	return jitter
+}
+
+// getMeanSuccessiveDiff returns the mean absolute difference between successive round trip times
+func getMeanSuccessiveDiff(pings []float64) float64 {
+	if len(pings) < 2 {
+		return 0
+	}
+
+	var total float64
+	for idx := 1; idx < len(pings); idx++ {
+		total += math.Abs(pings[idx] - pings[idx-1])
+	}
+
+	return total / float64(len(pings)-1)
+}
+
+// toMilliseconds converts a duration to milliseconds, keeping the fractional part
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
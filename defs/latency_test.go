@@ -0,0 +1,58 @@
+package defs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBufferbloatGrade(t *testing.T) {
+	tests := []struct {
+		name                   string
+		idle, download, upload float64
+		want                   string
+	}{
+		{"not measured", 20, 0, 0, ""},
+		{"no increase", 20, 22, 21, "A+"},
+		{"upload only", 20, 0, 45, "A"},
+		{"download worse", 20, 75, 30, "B"},
+		{"lower bound of C", 20, 80, 20, "C"},
+		{"heavy", 20, 300, 100, "D"},
+		{"severe", 20, 100, 500, "F"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BufferbloatGrade(tt.idle, tt.download, tt.upload); got != tt.want {
+				t.Errorf("BufferbloatGrade(%v, %v, %v) = %q, want %q", tt.idle, tt.download, tt.upload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetJitter(t *testing.T) {
+	tests := []struct {
+		name  string
+		pings []float64
+		alg   JitterAlgorithm
+		want  float64
+	}{
+		{"legacy empty", nil, JitterLegacy, 0},
+		{"legacy single", []float64{10}, JitterLegacy, 0},
+		{"legacy constant", []float64{10, 10, 10, 10}, JitterLegacy, 0},
+		{"legacy", []float64{10, 20, 15, 25}, JitterLegacy, 2.8},
+		{"rfc3550 empty", nil, JitterRFC3550, 0},
+		{"rfc3550 single", []float64{10}, JitterRFC3550, 0},
+		{"rfc3550", []float64{10, 20, 15, 25}, JitterRFC3550, 1.467285156},
+		{"msd empty", nil, JitterMSD, 0},
+		{"msd single", []float64{10}, JitterMSD, 0},
+		{"msd", []float64{10, 20, 15, 25}, JitterMSD, 25.0 / 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getJitter(tt.pings, tt.alg); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("getJitter(%v, %v) = %v, want %v", tt.pings, tt.alg, got, tt.want)
+			}
+		})
+	}
+}
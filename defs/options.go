@@ -6,6 +6,7 @@ const (
 	OptionIPv4Alt        = "4"
 	OptionIPv6           = "ipv6"
 	OptionIPv6Alt        = "6"
+	OptionDual           = "dual"
 	OptionNoDownload     = "no-download"
 	OptionNoUpload       = "no-upload"
 	OptionPingType       = "ping"
@@ -14,6 +15,8 @@ const (
 	OptionConcurrentAlt  = "n"
 	OptionPingCount      = "ping-count"
 	OptionPingCountAlt   = "c"
+	OptionNoBufferbloat  = "no-bufferbloat"
+	OptionJitter         = "jitter"
 	OptionBytes          = "bytes"
 	OptionMebiBytes      = "mebibytes"
 	OptionSimple         = "simple"
@@ -22,13 +25,47 @@ const (
 	OptionCSVDelimiter   = "csv-delimiter"
 	OptionCSVHeader      = "csv-header"
 	OptionJSON           = "json"
+	OptionFormat         = "format"
+	OptionOutput         = "output"
+	OptionOutputHeader   = "output-header"
+	OptionExporter       = "exporter"
+	OptionExporterEvery  = "exporter-interval"
+	OptionExporterMin    = "exporter-min-interval"
+	OptionMinDownload    = "min-download"
+	OptionMinUpload      = "min-upload"
+	OptionMaxPing        = "max-ping"
+	OptionMaxJitter      = "max-jitter"
+	OptionMaxLoss        = "max-loss"
+	OptionMaxRegression  = "max-regression"
+	OptionBaselineRuns   = "baseline-runs"
+	OptionCheckSummary   = "check-summary"
+	OptionTimings        = "timings"
+	OptionTrace          = "trace"
+	OptionTraceOnly      = "trace-only"
+	OptionTraceProtocol  = "trace-protocol"
+	OptionTraceCount     = "trace-count"
+	OptionTraceHops      = "trace-hops"
+	OptionTraceASN       = "trace-asn"
 	OptionList           = "list"
 	OptionListAlt        = "l"
+	OptionListProvince   = "list-province"
+	OptionListISP        = "list-isp"
+	OptionListType       = "list-type"
+	OptionListStack      = "list-stack"
+	OptionListName       = "list-name"
+	OptionListSort       = "list-sort"
 	OptionServer         = "server"
 	OptionServerAlt      = "s"
 	OptionServerGroup    = "group"
 	OptionServerGroupAlt = "g"
 	OptionExclude        = "exclude"
+	OptionLocalServers   = "local-servers"
+	OptionMergeServers   = "merge-servers"
+	OptionCacheTTL       = "cache-ttl"
+	OptionCacheDir       = "cache-dir"
+	OptionNoCache        = "no-cache"
+	OptionHistoryDir     = "history-dir"
+	OptionNoHistory      = "no-history"
 	OptionSource         = "source"
 	OptionInterface      = "interface"
 	OptionInterfaceAlt   = "i"
@@ -36,6 +73,12 @@ const (
 	OptionUploadSize     = "upload-size"
 	OptionDuration       = "duration"
 	OptionDurationAlt    = "t"
+	OptionVolume         = "volume"
+	OptionStopFirst      = "stop-first"
+	OptionInterval       = "interval"
+	OptionWarmup         = "warmup"
+	OptionSpeedMetric    = "speed-metric"
+	OptionProtocol       = "protocol"
 	OptionNoPreAllocate  = "no-pre-allocate"
 	OptionVersion        = "version"
 	OptionVersionAlt     = "v"
@@ -46,4 +89,13 @@ const (
 	OptionAPIHeader      = "api-header"
 	OptionTLSInsecure    = "tls-insecure"
 	OptionDebug          = "debug"
+	OptionListen         = "listen"
+	OptionServeTypes     = "types"
+	OptionMaxClients     = "max-clients"
+	OptionTLSCert        = "tls-cert"
+	OptionTLSKey         = "tls-key"
+	OptionISP            = "isp"
+	OptionSince          = "since"
+	OptionUntil          = "until"
+	OptionAggregate      = "aggregate"
 )
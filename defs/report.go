@@ -6,23 +6,105 @@ import (
 
 // JSONReport represents the output data fields in a JSON file
 type JSONReport struct {
-	Client  IPInfoResponse `json:"client"`
-	Results []Result       `json:"results"`
+	SchemaVersion int            `json:"schema_version"`
+	Run           RunParams      `json:"run"`
+	Client        IPInfoResponse `json:"client"`
+	Results       []Result       `json:"results"`
+	Interrupted   bool           `json:"interrupted"`
+}
+
+// ReportSchemaVersion is the version of the JSON report and the CSV columns, which is bumped when fields are changed.
+// New CSV columns are appended to the rows, after the ones of the previous versions
+const ReportSchemaVersion = 2
+
+// RunParams represents the parameters a test run was made with
+type RunParams struct {
+	Version     string `json:"version"`
+	Commit      string `json:"commit"`
+	Stack       string `json:"stack"`
+	Source      string `json:"source,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	PingType    string `json:"ping_type"`
+	Concurrent  string `json:"concurrent"`
+	Duration    int    `json:"duration"`
+	Volume      int    `json:"volume,omitempty"`
+	UploadSize  int    `json:"upload_size"`
+	Protocol    string `json:"protocol"`
+	Warmup      string `json:"warmup"`
+	SpeedMetric string `json:"speed_metric"`
+	NoDownload  bool   `json:"no_download,omitempty"`
+	NoUpload    bool   `json:"no_upload,omitempty"`
 }
 
 // Result represents the test's information
 type Result struct {
-	ID            string    `json:"id" csv:"ID"`
-	Name          string    `json:"name" csv:"Name"`
-	IP            string    `json:"ip" csv:"IP"`
-	Province      string    `json:"province" csv:"Province"`
-	City          string    `json:"city" csv:"City"`
-	ISP           string    `json:"isp" csv:"ISP"`
-	Timestamp     time.Time `json:"timestamp" csv:"Timestamp"`
-	BytesSent     uint64    `json:"bytes_sent" csv:"Sent"`
-	BytesReceived uint64    `json:"bytes_received" csv:"Received"`
-	Ping          float64   `json:"ping" csv:"Ping"`
-	Jitter        float64   `json:"jitter" csv:"Jitter"`
-	Upload        float64   `json:"upload" csv:"Upload"`
-	Download      float64   `json:"download" csv:"Download"`
+	ID                    string    `json:"id" csv:"ID"`
+	Name                  string    `json:"name" csv:"Name"`
+	IP                    string    `json:"ip" csv:"IP"`
+	Province              string    `json:"province" csv:"Province"`
+	City                  string    `json:"city" csv:"City"`
+	ISP                   string    `json:"isp" csv:"ISP"`
+	Timestamp             time.Time `json:"timestamp" csv:"Timestamp"`
+	BytesSent             uint64    `json:"bytes_sent" csv:"Sent"`
+	BytesReceived         uint64    `json:"bytes_received" csv:"Received"`
+	Ping                  float64   `json:"ping" csv:"Ping"`
+	Jitter                float64   `json:"jitter" csv:"Jitter"`
+	Upload                float64   `json:"upload" csv:"Upload"`
+	Download              float64   `json:"download" csv:"Download"`
+	UploadRaw             float64   `json:"upload_raw" csv:"UploadRaw"`
+	DownloadRaw           float64   `json:"download_raw" csv:"DownloadRaw"`
+	UploadWarmup          float64   `json:"upload_warmup" csv:"UploadWarmup"`
+	DownloadWarmup        float64   `json:"download_warmup" csv:"DownloadWarmup"`
+	SpeedMetric           string    `json:"speed_metric" csv:"SpeedMetric"`
+	UploadConcurrency     int       `json:"upload_concurrency" csv:"UploadConcurrency"`
+	DownloadConcurrency   int       `json:"download_concurrency" csv:"DownloadConcurrency"`
+	UploadElapsed         float64   `json:"upload_elapsed" csv:"UploadElapsed"`
+	DownloadElapsed       float64   `json:"download_elapsed" csv:"DownloadElapsed"`
+	UploadUnbalanced      bool      `json:"upload_unbalanced" csv:"UploadUnbalanced"`
+	DownloadUnbalanced    bool      `json:"download_unbalanced" csv:"DownloadUnbalanced"`
+	UploadProtocol        string    `json:"upload_protocol" csv:"UploadProtocol"`
+	DownloadProtocol      string    `json:"download_protocol" csv:"DownloadProtocol"`
+	UploadMean            float64   `json:"upload_mean" csv:"UploadMean"`
+	UploadPeak            float64   `json:"upload_peak" csv:"UploadPeak"`
+	UploadP10             float64   `json:"upload_p10" csv:"UploadP10"`
+	UploadP50             float64   `json:"upload_p50" csv:"UploadP50"`
+	UploadP90             float64   `json:"upload_p90" csv:"UploadP90"`
+	UploadStdDev          float64   `json:"upload_stddev" csv:"UploadStdDev"`
+	DownloadMean          float64   `json:"download_mean" csv:"DownloadMean"`
+	DownloadPeak          float64   `json:"download_peak" csv:"DownloadPeak"`
+	DownloadP10           float64   `json:"download_p10" csv:"DownloadP10"`
+	DownloadP50           float64   `json:"download_p50" csv:"DownloadP50"`
+	DownloadP90           float64   `json:"download_p90" csv:"DownloadP90"`
+	DownloadStdDev        float64   `json:"download_stddev" csv:"DownloadStdDev"`
+	UploadLatency         float64   `json:"upload_latency" csv:"UploadLatency"`
+	UploadLatencyJitter   float64   `json:"upload_latency_jitter" csv:"UploadLatencyJitter"`
+	DownloadLatency       float64   `json:"download_latency" csv:"DownloadLatency"`
+	DownloadLatencyJitter float64   `json:"download_latency_jitter" csv:"DownloadLatencyJitter"`
+	Bufferbloat           string    `json:"bufferbloat" csv:"Bufferbloat"`
+	PingMin               float64   `json:"ping_min" csv:"PingMin"`
+	PingMax               float64   `json:"ping_max" csv:"PingMax"`
+	PingMedian            float64   `json:"ping_median" csv:"PingMedian"`
+	PingStdDev            float64   `json:"ping_stddev" csv:"PingStdDev"`
+	PacketsSent           int       `json:"packets_sent" csv:"PacketsSent"`
+	PacketsReceived       int       `json:"packets_received" csv:"PacketsReceived"`
+	PacketLoss            float64   `json:"packet_loss" csv:"PacketLoss"`
+	JitterAlgorithm       string    `json:"jitter_algorithm" csv:"JitterAlgorithm"`
+	Stack                 string    `json:"stack" csv:"Stack"`
+	Type                  string    `json:"type" csv:"Type"`
+	PingType              string    `json:"ping_type" csv:"PingType"`
+
+	// SchemaVersion and Run are only filled for the CSV rows, as the JSON report has them once for all results
+	SchemaVersion int       `json:"-" csv:"SchemaVersion"`
+	Run           RunParams `json:"-" csv:"Run"`
+
+	PingSamples     []float64 `json:"ping_samples,omitempty" csv:"-"`
+	DownloadSamples []Sample  `json:"download_samples,omitempty" csv:"-"`
+	UploadSamples   []Sample  `json:"upload_samples,omitempty" csv:"-"`
+
+	DownloadConnections []ConnStats `json:"download_connections,omitempty" csv:"-"`
+	UploadConnections   []ConnStats `json:"upload_connections,omitempty" csv:"-"`
+
+	Timings map[string]PhaseTimings `json:"timings,omitempty" csv:"-"`
+
+	Trace []Hop `json:"trace,omitempty" csv:"-"`
 }
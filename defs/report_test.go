@@ -0,0 +1,88 @@
+package defs
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gocarina/gocsv"
+)
+
+func TestResultCSVHeader(t *testing.T) {
+	baseline := []string{
+		"ID", "Name", "IP", "Province", "City", "ISP", "Timestamp", "Sent", "Received", "Ping", "Jitter", "Upload",
+		"Download",
+	}
+	appended := []string{
+		"UploadRaw", "DownloadRaw", "UploadWarmup", "DownloadWarmup", "SpeedMetric", "UploadConcurrency",
+		"DownloadConcurrency", "UploadElapsed", "DownloadElapsed", "UploadUnbalanced", "DownloadUnbalanced",
+		"UploadProtocol", "DownloadProtocol", "UploadMean", "UploadPeak", "UploadP10", "UploadP50", "UploadP90",
+		"UploadStdDev", "DownloadMean", "DownloadPeak", "DownloadP10", "DownloadP50", "DownloadP90", "DownloadStdDev",
+		"UploadLatency", "UploadLatencyJitter", "DownloadLatency", "DownloadLatencyJitter", "Bufferbloat", "PingMin",
+		"PingMax", "PingMedian", "PingStdDev", "PacketsSent", "PacketsReceived", "PacketLoss", "JitterAlgorithm", "Stack",
+		"Type", "PingType", "SchemaVersion", "Run.Version", "Run.Commit", "Run.Stack", "Run.Source", "Run.Interface",
+		"Run.PingType", "Run.Concurrent", "Run.Duration", "Run.Volume", "Run.UploadSize", "Run.Protocol", "Run.Warmup",
+		"Run.SpeedMetric", "Run.NoDownload", "Run.NoUpload",
+	}
+
+	out, err := gocsv.MarshalString(&[]Result{})
+	if err != nil {
+		t.Fatalf("MarshalString() error = %v", err)
+	}
+	header := strings.Split(strings.TrimSpace(out), ",")
+	if !reflect.DeepEqual(header[:min(len(baseline), len(header))], baseline) {
+		t.Errorf("baseline columns = %v, want %v", header[:min(len(baseline), len(header))], baseline)
+	}
+	if !reflect.DeepEqual(header[min(len(baseline), len(header)):], appended) {
+		t.Errorf("appended columns = %v, want %v", header[min(len(baseline), len(header)):], appended)
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	run := RunParams{Version: "1.0.0", Stack: "ipv4", PingType: "udp", Concurrent: "auto", Duration: 15, Protocol: "h2"}
+	report := JSONReport{
+		SchemaVersion: ReportSchemaVersion,
+		Run:           run,
+		Results:       []Result{{ID: "1", Type: "perception", PingType: "tcp", SchemaVersion: ReportSchemaVersion, Run: run}},
+	}
+
+	b, err := json.Marshal(&report)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var got struct {
+		SchemaVersion int                      `json:"schema_version"`
+		Run           map[string]interface{}   `json:"run"`
+		Results       []map[string]interface{} `json:"results"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if got.SchemaVersion != 2 {
+		t.Errorf("schema_version = %d, want 2", got.SchemaVersion)
+	}
+	wantRun := map[string]interface{}{
+		"version": "1.0.0", "commit": "", "stack": "ipv4", "ping_type": "udp", "concurrent": "auto", "duration": 15.0,
+		"upload_size": 0.0, "protocol": "h2", "warmup": "", "speed_metric": "",
+	}
+	if !reflect.DeepEqual(got.Run, wantRun) {
+		t.Errorf("run = %v, want %v", got.Run, wantRun)
+	}
+
+	if len(got.Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(got.Results))
+	}
+	for key, want := range map[string]interface{}{"type": "perception", "ping_type": "tcp"} {
+		if got.Results[0][key] != want {
+			t.Errorf("results[0].%s = %v, want %v", key, got.Results[0][key], want)
+		}
+	}
+	// the schema version and the run parameters are only repeated in the CSV rows
+	for _, key := range []string{"schema_version", "run", "SchemaVersion", "Run"} {
+		if _, ok := got.Results[0][key]; ok {
+			t.Errorf("results[0] has %q, want it only in the report", key)
+		}
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -28,6 +30,34 @@ const (
 	StaticFile
 )
 
+// String returns the name of the server type, as given to `serve --types`
+func (t ServerType) String() string {
+	switch t {
+	case GlobalSpeed:
+		return "globalspeed"
+	case Perception:
+		return "perception"
+	case WirelessSpeed:
+		return "wirelessspeed"
+	case StaticFile:
+		return "staticfile"
+	default:
+		return "unknown"
+	}
+}
+
+// autoConcurrencyStep is the interval between checks of the throughput when tuning the number of connections
+var autoConcurrencyStep = time.Second
+
+const (
+	// maxAutoConcurrency is the upper limit of connections when tuning the number of connections
+	maxAutoConcurrency = 32
+	// maxConnFailures is the number of consecutive failed requests after which a connection is given up
+	maxConnFailures = 3
+	// tcpPingTimeout is the timeout of each TCP handshake when pinging via TCP
+	tcpPingTimeout = 2 * time.Second
+)
+
 // Server represents a speed test server
 type Server struct {
 	ID          string     `json:"id"`
@@ -47,6 +77,109 @@ type Server struct {
 	PingURI     string     `json:"ping"`
 	Type        ServerType `json:"type"`
 	PingType    PingType   `json:"-"`
+
+	// JitterAlgorithm is the algorithm used to calculate the jitter of the pings to this server
+	JitterAlgorithm JitterAlgorithm `json:"-"`
+	// Timings records the connection phases of the HTTP requests made to this server if not nil
+	Timings *Timings `json:"-"`
+	// Events receives the ping samples and the transfer progress of the tests of this server if not nil
+	Events *EventWriter `json:"-"`
+}
+
+// ServerInfo represents a server in the machine-readable server list, with the province, ISP and type named
+type ServerInfo struct {
+	ID          string `json:"id" csv:"ID"`
+	Name        string `json:"name" csv:"Name"`
+	IP          string `json:"ip" csv:"IP"`
+	IPv6        string `json:"ipv6" csv:"IPv6"`
+	Host        string `json:"host" csv:"Host"`
+	Port        uint16 `json:"port" csv:"Port"`
+	HTTPS       bool   `json:"https" csv:"HTTPS"`
+	ProvinceID  uint8  `json:"province_id" csv:"ProvinceID"`
+	Province    string `json:"province" csv:"Province"`
+	City        string `json:"city" csv:"City"`
+	ISPID       uint8  `json:"isp_id" csv:"ISPID"`
+	ISP         string `json:"isp" csv:"ISP"`
+	Type        string `json:"type" csv:"Type"`
+	DownloadURI string `json:"download" csv:"Download"`
+	UploadURI   string `json:"upload" csv:"Upload"`
+	PingURI     string `json:"ping" csv:"Ping"`
+}
+
+// Info returns the server as listed by --list, whose Province must have been filled in
+func (s *Server) Info() ServerInfo {
+	info := ServerInfo{
+		ID:          s.ID,
+		Name:        s.Name,
+		IP:          s.IP,
+		IPv6:        s.IPv6,
+		Host:        s.Host,
+		Port:        s.Port,
+		HTTPS:       s.HTTPS,
+		ProvinceID:  s.Prov,
+		Province:    s.Province,
+		City:        s.City,
+		ISPID:       s.ISP,
+		Type:        s.Type.String(),
+		DownloadURI: s.DownloadURI,
+		UploadURI:   s.UploadURI,
+		PingURI:     s.PingURI,
+	}
+	if isp, ok := ISPMap[s.ISP]; ok {
+		info.ISP = isp.Name
+	}
+	return info
+}
+
+// TransferOptions represents the parameters of a download or upload test
+type TransferOptions struct {
+	Silent     bool
+	UseBytes   bool
+	UseMebi    bool
+	NoPrealloc bool
+	Requests   int
+	UploadSize int
+	Duration   time.Duration
+	Volume     uint64
+	Interval   time.Duration
+	Warmup     time.Duration
+	AutoWarmup bool
+	Metric     SpeedMetric
+	Token      string
+	// Client is the HTTP client to make the requests with, the default one is used if nil
+	Client *http.Client
+
+	// ProbeLatency enables measuring the latency during the test with the server's ping type, using `Source` as source
+	// IP and `Network` as the ICMP/UDP ping network
+	ProbeLatency bool
+	Source       string
+	Network      string
+}
+
+// TransferResult represents the outcome of a download or upload test
+type TransferResult struct {
+	Mbps        float64
+	RawMbps     float64
+	Warmup      time.Duration
+	Bytes       uint64
+	Elapsed     time.Duration
+	Samples     []Sample
+	Stats       SpeedStats
+	Concurrency int
+	Latency     float64
+	Jitter      float64
+	Conns       []ConnStats
+	Unbalanced  bool
+	Protocol    string
+}
+
+// ConnStats represents the statistics of one connection of a transfer test
+type ConnStats struct {
+	Bytes      uint64 `json:"bytes"`
+	Requests   int    `json:"requests"`
+	Reconnects int    `json:"reconnects"`
+	BadStatus  int    `json:"bad_status"`
+	Errors     int    `json:"errors"`
 }
 
 func (s *Server) GetHost() string {
@@ -134,7 +267,9 @@ func (s *Server) IsUp() bool {
 	}
 	req.Header.Set("User-Agent", AndroidUA)
 
+	req, done := s.Timings.Trace(req, "is_up")
 	resp, err := http.DefaultClient.Do(req)
+	done()
 	if err != nil {
 		log.Debugf("Error checking for server status: %s", err)
 		return false
@@ -145,10 +280,13 @@ func (s *Server) IsUp() bool {
 	return (resp.StatusCode == http.StatusOK) || (resp.StatusCode == http.StatusForbidden) || (resp.StatusCode == http.StatusNotFound) || (resp.StatusCode == http.StatusBadGateway)
 }
 
-// ICMPPingAndJitter pings the server via ICMP echos and calculate the average ping and jitter
-func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, float64, error) {
-	if s.PingType == HTTP {
-		return s.PingAndJitter(count + 2)
+// ICMPPingAndJitter pings the server via ICMP echos and calculate the latency statistics and packet loss
+func (s *Server) ICMPPingAndJitter(ctx context.Context, count int, srcIp, network string) (*LatencyStats, error) {
+	switch s.PingType {
+	case HTTP:
+		return s.PingAndJitter(ctx, count+2)
+	case TCP:
+		return s.TCPPingAndJitter(ctx, count)
 	}
 
 	p := probing.New(s.Target)
@@ -164,46 +302,49 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 	if log.GetLevel() == log.DebugLevel {
 		p.Debug = true
 	}
-	if err := p.Run(); err != nil {
+	if s.Events != nil {
+		p.OnRecv = func(pkt *probing.Packet) {
+			s.Events.Emit(EventPingSample, PingEvent{Server: s.ID, Seq: pkt.Seq, RTT: toMilliseconds(pkt.Rtt)})
+		}
+	}
+	if err := p.RunWithContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		log.Debugf("Failed to ping target host: %s", err)
-		log.Debug("Will try TCP ping")
-		return s.PingAndJitter(count + 2)
+		log.Warnf("ICMP/UDP ping is not available for server %s (%s), falling back to TCP ping", s.Name, s.ID)
+		s.PingType = TCP
+		return s.TCPPingAndJitter(ctx, count)
 	}
 
 	stats := p.Statistics()
 
-	var lastPing, jitter float64
-	for idx, rtt := range stats.Rtts {
-		if idx != 0 {
-			instJitter := math.Abs(lastPing - float64(rtt.Milliseconds()))
-			if idx > 1 {
-				if jitter > instJitter {
-					jitter = jitter*0.7 + instJitter*0.3
-				} else {
-					jitter = instJitter*0.2 + jitter*0.8
-				}
-			}
+	if len(stats.Rtts) == 0 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-		lastPing = float64(rtt.Milliseconds())
+		s.PingType = TCP
+		log.Warnf("No ICMP/UDP pings returned for server %s (%s), falling back to TCP ping", s.Name, s.ID)
+		return s.TCPPingAndJitter(ctx, count)
 	}
 
-	if len(stats.Rtts) == 0 {
-		s.PingType = HTTP
-		log.Debugf("No ICMP/UDP pings returned for server %s (%s), trying TCP ping", s.Name, s.ID)
-		return s.PingAndJitter(count + 2)
+	var pings []float64
+	for _, rtt := range stats.Rtts {
+		pings = append(pings, toMilliseconds(rtt))
 	}
 
-	return float64(stats.AvgRtt.Milliseconds()), jitter, nil
+	return getLatencyStats(pings, stats.PacketsSent, s.JitterAlgorithm), nil
 }
 
-// PingAndJitter pings the server via accessing ping URL and calculate the average ping and jitter
-func (s *Server) PingAndJitter(count int) (float64, float64, error) {
+// PingAndJitter pings the server via accessing ping URL and calculate the latency statistics and packet loss. Failed
+// requests are counted as lost, an error is only returned when none of them succeeds
+func (s *Server) PingAndJitter(ctx context.Context, count int) (*LatencyStats, error) {
 	var pings []float64
 
-	req, err := http.NewRequest(http.MethodGet, s.PingURL().String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.PingURL().String(), nil)
 	if err != nil {
 		log.Debugf("Failed when creating HTTP request: %s", err)
-		return 0, 0, err
+		return nil, err
 	}
 
 	if s.Host != "" {
@@ -211,59 +352,114 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 	}
 	req.Header.Set("User-Agent", AndroidUA)
 
+	// discard first result due to handshake overhead
+	sent := count
+	if count > 1 {
+		sent = count - 1
+	}
+
+	var lastErr error
 	for i := 0; i < count; i++ {
 		start := time.Now()
-		resp, err := http.DefaultClient.Do(req)
+		r, done := s.Timings.Trace(req, "ping")
+		resp, err := http.DefaultClient.Do(r)
+		done()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			log.Debugf("Failed when making HTTP request: %s", err)
-			return 0, 0, err
+			lastErr = err
+			continue
 		}
 		io.Copy(io.Discard, resp.Body)
 		resp.Body.Close()
 
-		pings = append(pings, float64(time.Since(start).Milliseconds()))
+		if i == 0 && count > 1 {
+			continue
+		}
+		pings = append(pings, toMilliseconds(time.Since(start)))
+		s.Events.Emit(EventPingSample, PingEvent{Server: s.ID, Seq: i, RTT: pings[len(pings)-1]})
 	}
 
-	// discard first result due to handshake overhead
-	if len(pings) > 1 {
-		pings = pings[1:]
-	}
-
-	var lastPing, jitter float64
-	for idx, p := range pings {
-		if idx != 0 {
-			instJitter := math.Abs(lastPing - p)
-			if idx > 1 {
-				if jitter > instJitter {
-					jitter = jitter*0.7 + instJitter*0.3
-				} else {
-					jitter = instJitter*0.2 + jitter*0.8
-				}
+	if len(pings) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no pings returned")
+		}
+		return nil, lastErr
+	}
+
+	return getLatencyStats(pings, sent, s.JitterAlgorithm), nil
+}
+
+// TCPPingAndJitter pings the server by timing the TCP handshakes to its target and port, and calculate the latency
+// statistics and packet loss. The connections are made with the dialer of the default HTTP client, so the source address,
+// interface and IP version it is bound to apply. If none of the handshakes succeeds, it falls back to HTTP ping
+func (s *Server) TCPPingAndJitter(ctx context.Context, count int) (*LatencyStats, error) {
+	var pings []float64
+	for i := 0; i < count; i++ {
+		rtt, err := s.tcpHandshake(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
+			log.Debugf("Failed to connect to target host: %s", err)
+			continue
 		}
-		lastPing = p
+		pings = append(pings, rtt)
+		s.Events.Emit(EventPingSample, PingEvent{Server: s.ID, Seq: i, RTT: rtt})
+	}
+
+	if len(pings) == 0 {
+		s.PingType = HTTP
+		log.Warnf("No TCP pings returned for server %s (%s), falling back to HTTP ping", s.Name, s.ID)
+		return s.PingAndJitter(ctx, count+2)
 	}
 
-	return getAvg(pings), jitter, nil
+	return getLatencyStats(pings, count, s.JitterAlgorithm), nil
 }
 
-// Download performs the actual download test
-func (s *Server) Download(silent, useBytes, useMebi bool, requests int, duration time.Duration, token string) (float64, uint64, error) {
+// tcpHandshake connects to the server's target and port, and returns the time taken by the handshake in milliseconds
+func (s *Server) tcpHandshake(ctx context.Context) (float64, error) {
+	dial := (&net.Dialer{}).DialContext
+	if transport, ok := http.DefaultClient.Transport.(*http.Transport); ok && transport.DialContext != nil {
+		dial = transport.DialContext
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tcpPingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dial(ctx, "tcp", net.JoinHostPort(s.Target, strconv.Itoa(int(s.Port))))
+	if err != nil {
+		return 0, err
+	}
+	rtt := toMilliseconds(time.Since(start))
+	conn.Close()
+
+	return rtt, nil
+}
+
+// Download performs the actual download test, which stops early with the partial result when ctx is done
+func (s *Server) Download(ctx context.Context, opts TransferOptions) (*TransferResult, error) {
 	counter := NewCounter()
-	counter.SetMebi(useMebi)
+	counter.SetMebi(opts.UseMebi)
+	counter.SetInterval(opts.Interval)
+	counter.SetLimit(opts.Volume)
+	s.emitProgress(counter, EventDownloadProgress)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	uri := s.DownloadURL()
 	if s.Type == GlobalSpeed {
-		uri.RawQuery = fmt.Sprintf("key=%s", token)
+		uri.RawQuery = fmt.Sprintf("key=%s", opts.Token)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
 	if err != nil {
 		log.Debugf("Failed when creating HTTP request: %s", err)
-		return 0, 0, err
+		return nil, err
 	}
 
 	if s.Host != "" {
@@ -273,38 +469,43 @@ func (s *Server) Download(silent, useBytes, useMebi bool, requests int, duration
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Connection", "close")
 
-	downloadDone := make(chan struct{}, requests)
-
-	doDownload := func() {
-		resp, err := http.DefaultClient.Do(req)
+	doDownload := func(conn *ConnStats) bool {
+		r, done := s.Timings.Trace(req, "download")
+		resp, err := opts.client().Do(r)
+		done()
 		if err != nil {
 			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !os.IsTimeout(err) {
 				log.Debugf("Failed when making HTTP request: %s", err)
 			}
-		} else {
-			defer resp.Body.Close()
+			counter.track(conn, func(c *ConnStats) { c.Errors++ })
+			return false
+		}
+		defer resp.Body.Close()
+		counter.countProtocol(resp.Proto)
 
-			if resp.StatusCode != http.StatusOK {
-				log.Debugf("Failed to test download speed: %s", resp.Status)
-				return
-			}
+		if resp.StatusCode != http.StatusOK {
+			log.Debugf("Failed to test download speed: %s", resp.Status)
+			counter.track(conn, func(c *ConnStats) { c.BadStatus++ })
+			return false
+		}
 
-			if _, err = io.Copy(io.Discard, io.TeeReader(resp.Body, counter)); err != nil {
-				if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !os.IsTimeout(err) {
-					log.Debugf("Failed when reading HTTP response: %s", err)
-				}
+		if _, err = io.Copy(io.Discard, counter.Wrap(resp.Body, conn)); err != nil {
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !os.IsTimeout(err) {
+				log.Debugf("Failed when reading HTTP response: %s", err)
+				counter.track(conn, func(c *ConnStats) { c.Errors++ })
 			}
-
-			downloadDone <- struct{}{}
 		}
+
+		return true
 	}
 
 	counter.Start()
-	if !silent {
-		pb := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
+	var pb *spinner.Spinner
+	if !opts.Silent {
+		pb = spinner.New(spinner.CharSets[11], 100*time.Millisecond)
 		pb.Prefix = "Downloading...  "
 		pb.PostUpdate = func(s *spinner.Spinner) {
-			if useBytes {
+			if opts.UseBytes {
 				s.Suffix = fmt.Sprintf("  %s", counter.AvgHumanize())
 			} else {
 				s.Suffix = fmt.Sprintf("  %.2f Mbps", counter.AvgMbps())
@@ -312,55 +513,91 @@ func (s *Server) Download(silent, useBytes, useMebi bool, requests int, duration
 		}
 
 		pb.Start()
-		defer func() {
-			if useBytes {
-				pb.FinalMSG = fmt.Sprintf("Download:\t%s\n (data used: %s)", counter.AvgHumanize(), counter.BytesHumanize())
-			} else {
-				pb.FinalMSG = fmt.Sprintf("Download:\t%.2f Mbps (data used: %.2f MB)\n", counter.AvgMbps(), counter.MBytes())
-			}
-			pb.Stop()
-		}()
 	}
 
-	for i := 0; i < requests; i++ {
-		go doDownload()
-		time.Sleep(200 * time.Millisecond)
+	var latency, jitter float64
+	var probed chan struct{}
+	if opts.ProbeLatency {
+		probed = make(chan struct{})
+		go func() {
+			latency, jitter = s.ProbeLatency(ctx, opts.Source, opts.Network)
+			close(probed)
+		}()
 	}
-	timeout := time.After(duration)
-Loop:
-	for {
-		select {
-		case <-timeout:
-			ctx.Done()
-			break Loop
-		case <-downloadDone:
-			go doDownload()
+
+	var workers sync.WaitGroup
+	concurrency := runWorkers(ctx, counter, opts, doDownload, &workers)
+	cancel()
+	// let the interrupted requests finish, so that no bytes are counted once stopped and their timings are recorded
+	workers.Wait()
+	counter.Stop()
+
+	res := counter.Result(opts.Warmup, opts.AutoWarmup, opts.Metric)
+	res.Concurrency = concurrency
+	res.Conns = counter.Conns()
+	res.Unbalanced = unbalanced(res.Conns)
+	for i, conn := range res.Conns {
+		log.Debugf("Connection %d: %d bytes, %d requests, %d reconnects, %d non-200 responses, %d errors", i, conn.Bytes, conn.Requests, conn.Reconnects, conn.BadStatus, conn.Errors)
+	}
+	res.Protocol = transferProtocol(counter.Protocols())
+	if probed != nil {
+		<-probed
+		res.Latency, res.Jitter = latency, jitter
+	}
+	if pb != nil {
+		if opts.UseBytes {
+			pb.FinalMSG = fmt.Sprintf("Download:\t%s (data used: %s)\n", counter.Humanize(res.Mbps), counter.DataUsed(true))
+		} else {
+			pb.FinalMSG = fmt.Sprintf("Download:\t%.2f Mbps (data used: %s)\n", res.Mbps, counter.DataUsed(false))
 		}
+		if len(res.Samples) > 0 {
+			pb.FinalMSG += fmt.Sprintf("\t\t(%s)\n", counter.StatsHumanize(res.Stats, opts.UseBytes))
+		}
+		pb.Stop()
 	}
 
-	return counter.AvgMbps(), counter.Total(), nil
+	return res, nil
+}
+
+// emitProgress emits the throughput samples of counter as progress events of the given type
+func (s *Server) emitProgress(counter *BytesCounter, event string) {
+	if s.Events == nil {
+		return
+	}
+	counter.SetOnSample(func(sample Sample, total uint64) {
+		s.Events.Emit(event, ProgressEvent{
+			Server:  s.ID,
+			Elapsed: sample.Offset,
+			Speed:   sample.Speed,
+			Average: math.Round(counter.toMbps(float64(total)/sample.Offset)*100) / 100,
+			Bytes:   total,
+		})
+	})
 }
 
-// Upload performs the actual upload test
-func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests, uploadSize int, duration time.Duration, token string) (float64, uint64, error) {
+// Upload performs the actual upload test, which stops early with the partial result when ctx is done
+func (s *Server) Upload(ctx context.Context, opts TransferOptions) (*TransferResult, error) {
 	counter := NewCounter()
-	counter.SetMebi(useMebi)
-	counter.SetUploadSize(uploadSize)
+	counter.SetMebi(opts.UseMebi)
+	counter.SetUploadSize(opts.UploadSize)
+	counter.SetInterval(opts.Interval)
+	counter.SetLimit(opts.Volume)
+	s.emitProgress(counter, EventUploadProgress)
 
-	if noPrealloc {
+	if opts.NoPrealloc {
 		log.Info("Pre-allocation is disabled, performance might be lower!")
 		counter.reader = &SeekWrapper{rand.Reader}
 	} else {
 		counter.GenerateBlob()
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.UploadURL().String(), counter)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.UploadURL().String(), nil)
 	if err != nil {
 		log.Debugf("Failed when creating HTTP request: %s", err)
-		return 0, 0, err
+		return nil, err
 	}
 
 	if s.Host != "" {
@@ -370,38 +607,59 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests, up
 	if s.Type != WirelessSpeed {
 		req.Header.Set("Connection", "close")
 		req.Header.Set("Charset", "UTF-8")
-		req.Header.Set("Key", token)
+		req.Header.Set("Key", opts.Token)
 		req.Header.Set("Content-Type", "multipart/form-data;boundary=00content0boundary00")
 	} else {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 
-	uploadDone := make(chan struct{}, requests)
+	doUpload := func(conn *ConnStats) bool {
+		src := counter.source(conn)
+		r := req.Clone(ctx)
+		r.Body = io.NopCloser(src)
+		// lets the transport retry the request over another protocol, only counting the bytes sent by the retry
+		r.GetBody = func() (io.ReadCloser, error) {
+			src.discard()
+			src = counter.source(conn)
+			return io.NopCloser(src), nil
+		}
 
-	doUpload := func() {
-		resp, err := http.DefaultClient.Do(req)
+		r, done := s.Timings.Trace(r, "upload")
+		resp, err := opts.client().Do(r)
+		done()
 		if err != nil {
 			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !os.IsTimeout(err) {
 				log.Debugf("Failed when making HTTP request: %s", err)
 			}
-		} else {
-			defer resp.Body.Close()
-			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-				if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !os.IsTimeout(err) {
-					log.Debugf("Failed when reading HTTP response: %s", err)
-				}
-			}
+			counter.track(conn, func(c *ConnStats) { c.Errors++ })
+			return false
+		}
+		defer resp.Body.Close()
+		counter.countProtocol(resp.Proto)
 
-			uploadDone <- struct{}{}
+		if resp.StatusCode != http.StatusOK {
+			log.Debugf("Failed to test upload speed: %s", resp.Status)
+			counter.track(conn, func(c *ConnStats) { c.BadStatus++ })
+			return false
 		}
+
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !os.IsTimeout(err) {
+				log.Debugf("Failed when reading HTTP response: %s", err)
+				counter.track(conn, func(c *ConnStats) { c.Errors++ })
+			}
+		}
+
+		return true
 	}
 
 	counter.Start()
-	if !silent {
-		pb := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
+	var pb *spinner.Spinner
+	if !opts.Silent {
+		pb = spinner.New(spinner.CharSets[11], 100*time.Millisecond)
 		pb.Prefix = "Uploading...  "
 		pb.PostUpdate = func(s *spinner.Spinner) {
-			if useBytes {
+			if opts.UseBytes {
 				s.Suffix = fmt.Sprintf("  %s", counter.AvgHumanize())
 			} else {
 				s.Suffix = fmt.Sprintf("  %.2f Mbps", counter.AvgMbps())
@@ -409,31 +667,177 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests, up
 		}
 
 		pb.Start()
-		defer func() {
-			if useBytes {
-				pb.FinalMSG = fmt.Sprintf("Upload:\t\t%s (data used: %s)\n", counter.AvgHumanize(), counter.BytesHumanize())
-			} else {
-				pb.FinalMSG = fmt.Sprintf("Upload:\t\t%.2f Mbps (data used: %.2f MB)\n", counter.AvgMbps(), counter.MBytes())
+	}
+
+	var latency, jitter float64
+	var probed chan struct{}
+	if opts.ProbeLatency {
+		probed = make(chan struct{})
+		go func() {
+			latency, jitter = s.ProbeLatency(ctx, opts.Source, opts.Network)
+			close(probed)
+		}()
+	}
+
+	var workers sync.WaitGroup
+	concurrency := runWorkers(ctx, counter, opts, doUpload, &workers)
+	cancel()
+	// let the interrupted requests finish, so that no bytes are counted once stopped and their timings are recorded
+	workers.Wait()
+	counter.Stop()
+
+	res := counter.Result(opts.Warmup, opts.AutoWarmup, opts.Metric)
+	res.Concurrency = concurrency
+	res.Conns = counter.Conns()
+	res.Unbalanced = unbalanced(res.Conns)
+	for i, conn := range res.Conns {
+		log.Debugf("Connection %d: %d bytes, %d requests, %d reconnects, %d non-200 responses, %d errors", i, conn.Bytes, conn.Requests, conn.Reconnects, conn.BadStatus, conn.Errors)
+	}
+	res.Protocol = transferProtocol(counter.Protocols())
+	if probed != nil {
+		<-probed
+		res.Latency, res.Jitter = latency, jitter
+	}
+	if pb != nil {
+		if opts.UseBytes {
+			pb.FinalMSG = fmt.Sprintf("Upload:\t\t%s (data used: %s)\n", counter.Humanize(res.Mbps), counter.DataUsed(true))
+		} else {
+			pb.FinalMSG = fmt.Sprintf("Upload:\t\t%.2f Mbps (data used: %s)\n", res.Mbps, counter.DataUsed(false))
+		}
+		if len(res.Samples) > 0 {
+			pb.FinalMSG += fmt.Sprintf("\t\t(%s)\n", counter.StatsHumanize(res.Stats, opts.UseBytes))
+		}
+		pb.Stop()
+	}
+
+	return res, nil
+}
+
+// client returns the HTTP client to make the requests with
+func (o TransferOptions) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+// runWorkers keeps workers making requests with `do`, each on its own connection, until the test duration is over, the
+// transfer volume is reached, ctx is done or every worker gave up, and returns the number of workers still running.
+// When `opts.Requests` is 0, workers are added one by one for as long as the aggregate throughput keeps rising. Each
+// worker is added to wg, which is done once it returns
+func runWorkers(ctx context.Context, counter *BytesCounter, opts TransferOptions, do func(*ConnStats) bool, wg *sync.WaitGroup) int {
+	// the workers giving up are not counted, and the last one to give up signals gone
+	var workers atomic.Int32
+	gone := make(chan struct{}, 1)
+	spawn := func() {
+		workers.Add(1)
+		conn := counter.newConn()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			failures := 0
+			for ctx.Err() == nil {
+				counter.track(conn, func(c *ConnStats) { c.Requests++ })
+				if do(conn) {
+					failures = 0
+					continue
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				// reconnect after a short pause, unless the connection keeps failing
+				if failures++; failures >= maxConnFailures {
+					log.Debugf("Connection failed %d times in a row, giving up", failures)
+					if workers.Add(-1) == 0 {
+						select {
+						case gone <- struct{}{}:
+						default:
+						}
+					}
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(200 * time.Millisecond):
+					counter.track(conn, func(c *ConnStats) { c.Reconnects++ })
+				}
 			}
-			pb.Stop()
 		}()
 	}
 
-	for i := 0; i < requests; i++ {
-		go doUpload()
-		time.Sleep(200 * time.Millisecond)
+	var step <-chan time.Time
+	if opts.Requests > 0 {
+		for i := 0; i < opts.Requests; i++ {
+			spawn()
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-ctx.Done():
+				return int(workers.Load())
+			case <-counter.Full():
+				return int(workers.Load())
+			}
+		}
+	} else {
+		spawn()
+		ticker := time.NewTicker(autoConcurrencyStep)
+		defer ticker.Stop()
+		step = ticker.C
 	}
-	timeout := time.After(duration)
-Loop:
+
+	var last uint64
+	var best float64
+	timeout := time.After(opts.Duration)
 	for {
 		select {
 		case <-timeout:
-			ctx.Done()
-			break Loop
-		case <-uploadDone:
-			go doUpload()
+			return int(workers.Load())
+		case <-ctx.Done():
+			log.Debugf("Transfer test interrupted")
+			return int(workers.Load())
+		case <-counter.Full():
+			log.Debugf("Transfer volume of %d bytes reached", counter.Limit())
+			return int(workers.Load())
+		case <-gone:
+			// a worker may have been spawned since the signal
+			if workers.Load() == 0 {
+				log.Debugf("Every connection gave up")
+				return 0
+			}
+		case <-step:
+			total := counter.Total()
+			speed := counter.toMbps(float64(total-last) / autoConcurrencyStep.Seconds())
+			last = total
+
+			if live := workers.Load(); speed > best*1.1 && live < maxAutoConcurrency {
+				log.Debugf("Throughput rose to %.2f Mbps with %d connections, adding one more", speed, live)
+				best = speed
+				spawn()
+			} else {
+				log.Debugf("Throughput plateaued at %.2f Mbps, using %d connections", speed, live)
+				step = nil
+			}
 		}
 	}
+}
 
-	return counter.AvgMbps(), counter.Total(), nil
+// unbalanced reports whether some of the connections kept failing and barely contributed to the throughput, so the
+// result is carried by the remaining ones
+func unbalanced(conns []ConnStats) bool {
+	if len(conns) < 2 {
+		return false
+	}
+
+	var total uint64
+	for _, conn := range conns {
+		total += conn.Bytes
+	}
+
+	fair := total / uint64(len(conns))
+	for _, conn := range conns {
+		if conn.BadStatus+conn.Errors > 0 && conn.Bytes < fair/10 {
+			return true
+		}
+	}
+	return false
 }
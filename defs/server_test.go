@@ -0,0 +1,290 @@
+package defs
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServer returns a server of the given type whose target is the httptest server
+func testServer(t *testing.T, srv *httptest.Server, typ ServerType) *Server {
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return &Server{ID: "1", Name: "test", Target: host, Port: uint16(p), Type: typ}
+}
+
+// stream writes the response body until the request is done
+func stream(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 32*1024)
+	for r.Context().Err() == nil {
+		if _, err := w.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+func TestTCPPingAndJitter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	// the handshakes are made with the dialer of the default client, which is pointed at the closed port when refused
+	transport := http.DefaultClient.Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+
+	tests := []struct {
+		name         string
+		refused      func(n int32) bool
+		wantReceived int
+		wantLoss     float64
+		wantType     PingType
+		wantErr      bool
+	}{
+		{"open port", func(n int32) bool { return false }, 4, 0, TCP, false},
+		{"closed port every other handshake", func(n int32) bool { return n%2 == 0 }, 2, 50, TCP, false},
+		{"closed port", func(n int32) bool { return true }, 0, 0, HTTP, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dials atomic.Int32
+			http.DefaultClient.Transport = &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					if tt.refused(dials.Add(1)) {
+						addr = closed
+					}
+					return (&net.Dialer{}).DialContext(ctx, network, addr)
+				},
+			}
+
+			s := testServer(t, srv, Perception)
+			s.PingType = TCP
+			stats, err := s.TCPPingAndJitter(context.Background(), 4)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TCPPingAndJitter() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if s.PingType != tt.wantType {
+				t.Errorf("ping type = %s, want %s", s.PingType, tt.wantType)
+			}
+			if err != nil {
+				return
+			}
+			if stats.Sent != 4 || stats.Received != tt.wantReceived || stats.Loss != tt.wantLoss {
+				t.Errorf("TCPPingAndJitter() = %d sent, %d received, %.0f%% loss; want 4, %d, %.0f%%", stats.Sent,
+					stats.Received, stats.Loss, tt.wantReceived, tt.wantLoss)
+			}
+			for _, rtt := range stats.Rtts {
+				if rtt <= 0 || rtt >= toMilliseconds(tcpPingTimeout) {
+					t.Errorf("handshake timed at %.3f ms", rtt)
+				}
+			}
+		})
+	}
+}
+
+func TestTransferConnStats(t *testing.T) {
+	accepted := func(n int64, w http.ResponseWriter, r *http.Request) { stream(w, r) }
+	refused := func(n int64, w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) }
+	// only the first request is accepted, and its body streamed for the whole test
+	first := func(n int64, w http.ResponseWriter, r *http.Request) {
+		if n == 1 {
+			stream(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	// the bytes are only compared to 0, as their number depends on the speed of the test, and not at all if nil
+	tests := []struct {
+		name           string
+		upload         bool
+		handler        func(n int64, w http.ResponseWriter, r *http.Request)
+		want           []ConnStats
+		wantBytes      []bool
+		wantUnbalanced bool
+	}{
+		{
+			name:      "download accepted",
+			handler:   accepted,
+			want:      []ConnStats{{Requests: 1}, {Requests: 1}},
+			wantBytes: []bool{true, true},
+		},
+		{
+			name:      "download refused",
+			handler:   refused,
+			want:      []ConnStats{{Requests: 3, Reconnects: 2, BadStatus: 3}, {Requests: 3, Reconnects: 2, BadStatus: 3}},
+			wantBytes: []bool{false, false},
+		},
+		{
+			name:    "upload refused",
+			upload:  true,
+			handler: refused,
+			// the payload written before the response is counted, whether the server reads it or not
+			want: []ConnStats{{Requests: 3, Reconnects: 2, BadStatus: 3}, {Requests: 3, Reconnects: 2, BadStatus: 3}},
+		},
+		{
+			name:           "download from one connection",
+			handler:        first,
+			want:           []ConnStats{{Requests: 1}, {Requests: 3, Reconnects: 2, BadStatus: 3}},
+			wantBytes:      []bool{true, false},
+			wantUnbalanced: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.handler(requests.Add(1), w, r)
+			}))
+			defer srv.Close()
+
+			s := testServer(t, srv, Perception)
+			opts := TransferOptions{
+				Silent:     true,
+				Requests:   2,
+				UploadSize: 1024,
+				Duration:   time.Second,
+				Interval:   100 * time.Millisecond,
+			}
+			var res *TransferResult
+			var err error
+			if tt.upload {
+				res, err = s.Upload(context.Background(), opts)
+			} else {
+				res, err = s.Download(context.Background(), opts)
+			}
+			if err != nil {
+				t.Fatalf("transfer error = %v", err)
+			}
+
+			if len(res.Conns) != len(tt.want) {
+				t.Fatalf("got %d connections, want %d", len(res.Conns), len(tt.want))
+			}
+			for i, conn := range res.Conns {
+				if tt.wantBytes != nil && (conn.Bytes > 0) != tt.wantBytes[i] {
+					t.Errorf("connection %d: %d bytes, want bytes %t", i, conn.Bytes, tt.wantBytes[i])
+				}
+				conn.Bytes = 0
+				if conn != tt.want[i] {
+					t.Errorf("connection %d: %+v, want %+v", i, conn, tt.want[i])
+				}
+			}
+			if res.Unbalanced != tt.wantUnbalanced {
+				t.Errorf("Unbalanced = %t, want %t", res.Unbalanced, tt.wantUnbalanced)
+			}
+		})
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	step := autoConcurrencyStep
+	autoConcurrencyStep = 200 * time.Millisecond
+	defer func() { autoConcurrencyStep = step }()
+	const duration = 1200 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		requests int
+		// capacity is the number of connections adding throughput, the others adding none or failing
+		capacity  int
+		failing   bool
+		want      int
+		wantEarly bool
+	}{
+		{"ramp-up until the plateau", 0, 3, false, 4, false},
+		{"plateau with one connection", 0, 1, false, 2, false},
+		{"failing connection added", 0, 1, true, 1, false},
+		{"every connection failing", 0, 0, true, 0, true},
+		{"fixed", 3, 1, false, 3, false},
+		{"fixed with failing connections", 3, 1, true, 1, false},
+		{"every fixed connection failing", 2, 0, true, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := NewCounter()
+
+			// each connection adds 1 MB/s for the time elapsed since its previous request
+			var lock sync.Mutex
+			index := make(map[*ConnStats]int)
+			last := make(map[*ConnStats]time.Time)
+			do := func(conn *ConnStats) bool {
+				time.Sleep(time.Millisecond)
+				lock.Lock()
+				i, ok := index[conn]
+				if !ok {
+					i = len(index)
+					index[conn] = i
+					last[conn] = time.Now()
+				}
+				elapsed := time.Since(last[conn])
+				last[conn] = time.Now()
+				lock.Unlock()
+
+				if i >= tt.capacity {
+					return !tt.failing
+				}
+				counter.lock.Lock()
+				counter.add(uint64(elapsed.Seconds()*1000000), conn)
+				counter.lock.Unlock()
+				return true
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			start := time.Now()
+			got := runWorkers(ctx, counter, TransferOptions{Requests: tt.requests, Duration: duration}, do, &wg)
+			elapsed := time.Since(start)
+			cancel()
+			wg.Wait()
+
+			if got != tt.want {
+				t.Errorf("runWorkers() = %d, want %d", got, tt.want)
+			}
+			if early := elapsed < duration; early != tt.wantEarly {
+				t.Errorf("runWorkers() returned after %s, want early %t", elapsed, tt.wantEarly)
+			}
+		})
+	}
+}
+
+func TestUnbalanced(t *testing.T) {
+	tests := []struct {
+		name  string
+		conns []ConnStats
+		want  bool
+	}{
+		{"no connection", nil, false},
+		{"single failing connection", []ConnStats{{Bytes: 0, Errors: 3}}, false},
+		{"balanced", []ConnStats{{Bytes: 1000}, {Bytes: 900}}, false},
+		{"uneven without failures", []ConnStats{{Bytes: 1000}, {Bytes: 10}}, false},
+		{"failures with a fair share", []ConnStats{{Bytes: 1000}, {Bytes: 800, BadStatus: 1}}, false},
+		{"failing connection", []ConnStats{{Bytes: 1000}, {Bytes: 10, Errors: 3}}, true},
+		{"refused connection", []ConnStats{{Bytes: 1000}, {Bytes: 1000}, {BadStatus: 3}}, true},
+		{"every connection failing", []ConnStats{{BadStatus: 3}, {Errors: 3}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unbalanced(tt.conns); got != tt.want {
+				t.Errorf("unbalanced(%+v) = %t, want %t", tt.conns, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package defs
+
+import (
+	"math"
+	"sort"
+)
+
+// SpeedStats represents the distribution of the sampled throughput in mbits/second
+type SpeedStats struct {
+	Mean   float64
+	Peak   float64
+	P10    float64
+	P50    float64
+	P90    float64
+	StdDev float64
+}
+
+// getSpeedStats returns the distribution of the given speeds
+func getSpeedStats(vals []float64) SpeedStats {
+	if len(vals) == 0 {
+		return SpeedStats{}
+	}
+
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+
+	return SpeedStats{
+		Mean:   getAvg(sorted),
+		Peak:   sorted[len(sorted)-1],
+		P10:    getPercentile(sorted, 10),
+		P50:    getPercentile(sorted, 50),
+		P90:    getPercentile(sorted, 90),
+		StdDev: getStdDev(sorted),
+	}
+}
+
+// getPercentile returns the p-th percentile of a sorted float64 array, interpolating between the closest ranks
+func getPercentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// getStdDev returns the population standard deviation of a float64 array
+func getStdDev(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+
+	avg := getAvg(vals)
+	var sum float64
+	for _, v := range vals {
+		sum += (v - avg) * (v - avg)
+	}
+
+	return math.Sqrt(sum / float64(len(vals)))
+}
+
+// LatencyStats represents the distribution of the round trip times in milliseconds, and the packet loss of a ping test
+type LatencyStats struct {
+	Avg      float64
+	Min      float64
+	Max      float64
+	Median   float64
+	StdDev   float64
+	Jitter   float64
+	Sent     int
+	Received int
+	Loss     float64
+	Rtts     []float64
+}
+
+// getLatencyStats returns the distribution of the given round trip times, with `sent` being the number of probes sent
+// and the jitter calculated by `alg`
+func getLatencyStats(pings []float64, sent int, alg JitterAlgorithm) *LatencyStats {
+	stats := &LatencyStats{Sent: sent, Received: len(pings), Rtts: pings}
+	if sent > 0 {
+		stats.Loss = float64(sent-len(pings)) / float64(sent) * 100
+	}
+	if len(pings) == 0 {
+		return stats
+	}
+
+	sorted := append([]float64(nil), pings...)
+	sort.Float64s(sorted)
+
+	stats.Avg = getAvg(sorted)
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	stats.Median = getPercentile(sorted, 50)
+	stats.StdDev = getStdDev(sorted)
+	stats.Jitter = getJitter(pings, alg)
+	return stats
+}
@@ -0,0 +1,99 @@
+package defs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGetPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{"empty", nil, 50, 0},
+		{"single value", []float64{42}, 90, 42},
+		{"minimum", []float64{1, 2, 3, 4, 5}, 0, 1},
+		{"maximum", []float64{1, 2, 3, 4, 5}, 100, 5},
+		{"exact rank", []float64{1, 2, 3, 4, 5}, 50, 3},
+		{"interpolated", []float64{10, 20, 30, 40}, 50, 25},
+		{"interpolated p90", []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, 90, 91},
+		{"interpolated p10", []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, 10, 19},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getPercentile(tt.sorted, tt.p); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("getPercentile(%v, %v) = %v, want %v", tt.sorted, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetStdDev(t *testing.T) {
+	tests := []struct {
+		name string
+		vals []float64
+		want float64
+	}{
+		{"empty", nil, 0},
+		{"constant", []float64{5, 5, 5}, 0},
+		{"population", []float64{2, 4, 4, 4, 5, 5, 7, 9}, 2},
+		{"two values", []float64{1, 3}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getStdDev(tt.vals); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("getStdDev(%v) = %v, want %v", tt.vals, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSpeedStats(t *testing.T) {
+	got := getSpeedStats([]float64{50, 10, 40, 20, 30})
+	want := SpeedStats{Mean: 30, Peak: 50, P10: 14, P50: 30, P90: 46, StdDev: math.Sqrt(200)}
+	if math.Abs(got.Mean-want.Mean) > 1e-9 || got.Peak != want.Peak || math.Abs(got.P10-want.P10) > 1e-9 ||
+		math.Abs(got.P50-want.P50) > 1e-9 || math.Abs(got.P90-want.P90) > 1e-9 || math.Abs(got.StdDev-want.StdDev) > 1e-9 {
+		t.Errorf("getSpeedStats() = %+v, want %+v", got, want)
+	}
+
+	if got := getSpeedStats(nil); got != (SpeedStats{}) {
+		t.Errorf("getSpeedStats(nil) = %+v, want zero", got)
+	}
+}
+
+func TestGetLatencyStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		pings []float64
+		sent  int
+		want  LatencyStats
+	}{
+		{"no replies", nil, 3, LatencyStats{Sent: 3, Loss: 100}},
+		{"nothing sent", nil, 0, LatencyStats{}},
+		{"no loss", []float64{10, 10, 10}, 3, LatencyStats{Avg: 10, Min: 10, Max: 10, Median: 10, Sent: 3, Received: 3}},
+		{"loss", []float64{30, 10, 20, 40}, 5, LatencyStats{
+			Avg: 25, Min: 10, Max: 40, Median: 25, StdDev: math.Sqrt(125), Jitter: 5.6, Sent: 5, Received: 4, Loss: 20,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getLatencyStats(tt.pings, tt.sent, JitterLegacy)
+			for _, v := range []struct{ got, want float64 }{
+				{got.Avg, tt.want.Avg}, {got.Min, tt.want.Min}, {got.Max, tt.want.Max}, {got.Median, tt.want.Median},
+				{got.StdDev, tt.want.StdDev}, {got.Jitter, tt.want.Jitter}, {got.Loss, tt.want.Loss},
+			} {
+				if math.Abs(v.got-v.want) > 1e-9 {
+					t.Fatalf("getLatencyStats(%v, %d) = %+v, want %+v", tt.pings, tt.sent, *got, tt.want)
+				}
+			}
+			if got.Sent != tt.want.Sent || got.Received != tt.want.Received {
+				t.Errorf("getLatencyStats(%v, %d) = %+v, want %+v", tt.pings, tt.sent, *got, tt.want)
+			}
+		})
+	}
+}
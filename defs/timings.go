@@ -0,0 +1,171 @@
+package defs
+
+import (
+	"crypto/tls"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PhaseTimings represents the average time taken by each phase of the HTTP requests of a test in milliseconds. Each
+// phase is averaged over the requests it happened in, so DNS, Connect and TLS only count the requests that opened a new
+// connection, and TTFB only counts the requests that received a response
+type PhaseTimings struct {
+	Requests int     `json:"requests"`
+	DNS      float64 `json:"dns"`
+	Connect  float64 `json:"connect"`
+	TLS      float64 `json:"tls"`
+	TTFB     float64 `json:"ttfb"`
+}
+
+// Timings records the phases of the traced HTTP requests, grouped by the test they belong to
+type Timings struct {
+	lock  sync.Mutex
+	tests map[string][]requestTiming
+}
+
+// requestTiming represents the phases of a single HTTP request, a zero value meaning the phase did not happen
+type requestTiming struct {
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+}
+
+// NewTimings creates a new timing recorder
+func NewTimings() *Timings {
+	return &Timings{tests: make(map[string][]requestTiming)}
+}
+
+// Trace returns a shallow copy of req whose phases are traced, and a function to be called once the request is done to
+// record them under the given test name. It returns req itself and a no-op if t is nil
+func (t *Timings) Trace(req *http.Request, test string) (*http.Request, func()) {
+	if t == nil {
+		return req, func() {}
+	}
+
+	var lock sync.Mutex
+	var timing requestTiming
+	var start, dnsStart, connectStart, tlsStart time.Time
+	since := func(from time.Time) time.Duration {
+		lock.Lock()
+		defer lock.Unlock()
+		if from.IsZero() {
+			return 0
+		}
+		return time.Since(from)
+	}
+	mark := func(at *time.Time) {
+		lock.Lock()
+		*at = time.Now()
+		lock.Unlock()
+	}
+
+	trace := &httptrace.ClientTrace{
+		GetConn:  func(string) { mark(&start) },
+		DNSStart: func(httptrace.DNSStartInfo) { mark(&dnsStart) },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			d := since(dnsStart)
+			lock.Lock()
+			timing.dns = d
+			lock.Unlock()
+		},
+		ConnectStart: func(string, string) { mark(&connectStart) },
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil {
+				return
+			}
+			d := since(connectStart)
+			lock.Lock()
+			timing.connect = d
+			lock.Unlock()
+		},
+		TLSHandshakeStart: func() { mark(&tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				return
+			}
+			d := since(tlsStart)
+			lock.Lock()
+			timing.tls = d
+			lock.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			d := since(start)
+			lock.Lock()
+			timing.ttfb = d
+			lock.Unlock()
+		},
+	}
+
+	done := func() {
+		lock.Lock()
+		recorded := timing
+		lock.Unlock()
+
+		log.Debugf("%s request to %s: DNS %.3f ms, connect %.3f ms, TLS %.3f ms, TTFB %.3f ms", test, req.URL.Host,
+			toMilliseconds(recorded.dns), toMilliseconds(recorded.connect), toMilliseconds(recorded.tls), toMilliseconds(recorded.ttfb))
+
+		t.lock.Lock()
+		t.tests[test] = append(t.tests[test], recorded)
+		t.lock.Unlock()
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), done
+}
+
+// Summary returns the average phase timings of each test
+func (t *Timings) Summary() map[string]PhaseTimings {
+	if t == nil {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.tests) == 0 {
+		return nil
+	}
+
+	summary := make(map[string]PhaseTimings, len(t.tests))
+	for test, timings := range t.tests {
+		var dns, connect, handshake, ttfb []float64
+		for _, timing := range timings {
+			if timing.dns > 0 {
+				dns = append(dns, toMilliseconds(timing.dns))
+			}
+			if timing.connect > 0 {
+				connect = append(connect, toMilliseconds(timing.connect))
+			}
+			if timing.tls > 0 {
+				handshake = append(handshake, toMilliseconds(timing.tls))
+			}
+			if timing.ttfb > 0 {
+				ttfb = append(ttfb, toMilliseconds(timing.ttfb))
+			}
+		}
+
+		summary[test] = PhaseTimings{
+			Requests: len(timings),
+			DNS:      getPhaseAvg(dns),
+			Connect:  getPhaseAvg(connect),
+			TLS:      getPhaseAvg(handshake),
+			TTFB:     getPhaseAvg(ttfb),
+		}
+	}
+
+	return summary
+}
+
+// getPhaseAvg returns the average of the durations of a phase rounded to microseconds, or 0 if it never happened
+func getPhaseAvg(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+
+	return math.Round(getAvg(vals)*1000) / 1000
+}
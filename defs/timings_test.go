@@ -0,0 +1,85 @@
+package defs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimingsSummary(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name  string
+		tests map[string][]requestTiming
+		want  map[string]PhaseTimings
+	}{
+		{"no request", map[string][]requestTiming{}, nil},
+		{
+			"new connection",
+			map[string][]requestTiming{"ping": {{dns: 2 * ms, connect: 10 * ms, tls: 20 * ms, ttfb: 40 * ms}}},
+			map[string]PhaseTimings{"ping": {Requests: 1, DNS: 2, Connect: 10, TLS: 20, TTFB: 40}},
+		},
+		{
+			"reused connection",
+			map[string][]requestTiming{"ping": {{connect: 10 * ms, ttfb: 30 * ms}, {ttfb: 10 * ms}}},
+			map[string]PhaseTimings{"ping": {Requests: 2, Connect: 10, TTFB: 20}},
+		},
+		{
+			"failed request",
+			map[string][]requestTiming{"download": {{dns: 2 * ms}, {}}},
+			map[string]PhaseTimings{"download": {Requests: 2, DNS: 2}},
+		},
+		{
+			"rounded to microseconds",
+			map[string][]requestTiming{"upload": {{ttfb: 1234567 * time.Nanosecond}}},
+			map[string]PhaseTimings{"upload": {Requests: 1, TTFB: 1.235}},
+		},
+		{
+			"several tests",
+			map[string][]requestTiming{"is_up": {{connect: 5 * ms, ttfb: 8 * ms}}, "ping": {{ttfb: 3 * ms}, {ttfb: 5 * ms}}},
+			map[string]PhaseTimings{"is_up": {Requests: 1, Connect: 5, TTFB: 8}, "ping": {Requests: 2, TTFB: 4}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timings := &Timings{tests: tt.tests}
+			if got := timings.Summary(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Summary() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	var timings *Timings
+	if got := timings.Summary(); got != nil {
+		t.Errorf("Summary() of nil timings = %+v, want nil", got)
+	}
+}
+
+func TestTimingsTrace(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	timings := NewTimings()
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req, done := timings.Trace(req, "ping")
+		resp, err := srv.Client().Do(req)
+		done()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// the second request reuses the connection, and the server is requested by IP
+	got := timings.Summary()["ping"]
+	if got.Requests != 2 || got.DNS != 0 || got.Connect <= 0 || got.TLS <= 0 || got.TTFB <= 0 {
+		t.Errorf("Summary() = %+v, want 2 requests with the connect, TLS and TTFB phases", got)
+	}
+	if recorded := timings.tests["ping"]; len(recorded) != 2 || recorded[1].connect != 0 || recorded[1].tls != 0 {
+		t.Errorf("recorded %+v, want the second request on the same connection", recorded)
+	}
+}
@@ -0,0 +1,437 @@
+package defs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// traceBasePort is the first destination port of UDP probes, the sequence number of a probe being added to it
+const traceBasePort = 33434
+
+// MaxTraceProbes is the maximum number of probes of a path analysis, so the sequence numbers fit in the UDP ports above
+// traceBasePort, and in the 16 bits of ICMP sequence numbers
+const MaxTraceProbes = 65535 - traceBasePort
+
+// TraceOptions represents the parameters of a path analysis
+type TraceOptions struct {
+	// Protocol is the type of probes, ICMP, UDP or TCP
+	Protocol PingType
+	MaxHops  int
+	Count    int
+	Timeout  time.Duration
+	Source   string
+	// LookupASN maps the hops to ISP backbones by their AS, which sends their IPs to the DNS zone of Team Cymru
+	LookupASN bool
+}
+
+// Hop represents the replies from one hop of the path to a server, with the latency in milliseconds
+type Hop struct {
+	TTL      int     `json:"ttl"`
+	IP       string  `json:"ip"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss"`
+	Avg      float64 `json:"avg"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	StdDev   float64 `json:"stddev"`
+	Backbone string  `json:"backbone,omitempty"`
+}
+
+// tracer sends probes with increasing TTL to a destination, and matches the ICMP replies to them
+type tracer struct {
+	opts    TraceOptions
+	dst     net.IP
+	port    int
+	v6      bool
+	id      int
+	conn    *icmp.PacketConn
+	udp     net.PacketConn
+	replies chan traceReply
+
+	// ports maps the source ports of the TCP probes, which are assigned by the kernel, to their sequence numbers
+	lock  sync.Mutex
+	ports map[int]int
+}
+
+// traceReply represents a reply to the probe of sequence number `seq`, `reached` being true if it is from the
+// destination
+type traceReply struct {
+	seq     int
+	from    net.IP
+	at      time.Time
+	reached bool
+}
+
+// Trace analyses the path to the server like mtr, sending `opts.Count` rounds of probes with increasing TTL and
+// returning the loss and latency of each hop. The ICMP replies are read from a raw socket, which requires root or the
+// `cap_net_raw` capability on Linux
+func (s *Server) Trace(ctx context.Context, opts TraceOptions) ([]Hop, error) {
+	if probes := opts.Count * opts.MaxHops; probes > MaxTraceProbes {
+		return nil, fmt.Errorf("%d probes exceed the maximum of %d", probes, MaxTraceProbes)
+	}
+
+	dst := net.ParseIP(s.Target)
+	if dst == nil {
+		addr, err := net.ResolveIPAddr("ip", s.Target)
+		if err != nil {
+			return nil, err
+		}
+		dst = addr.IP
+	}
+
+	// the replies are buffered for every probe of the trace, as the ones to previous rounds can arrive late
+	t := &tracer{
+		opts:    opts,
+		dst:     dst,
+		port:    int(s.Port),
+		v6:      dst.To4() == nil,
+		id:      os.Getpid() & 0xffff,
+		replies: make(chan traceReply, opts.MaxHops*opts.Count),
+		ports:   make(map[int]int),
+	}
+
+	network, address := "ip4:icmp", "0.0.0.0"
+	if t.v6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	if opts.Source != "" {
+		address = opts.Source
+	}
+
+	var err error
+	if t.conn, err = icmp.ListenPacket(network, address); err != nil {
+		return nil, fmt.Errorf("failed to listen for ICMP replies, root or `cap_net_raw` is required: %w", err)
+	}
+	defer t.conn.Close()
+
+	if opts.Protocol == UDP {
+		network = "udp4"
+		if t.v6 {
+			network = "udp6"
+		}
+		if t.udp, err = net.ListenPacket(network, net.JoinHostPort(opts.Source, "0")); err != nil {
+			return nil, err
+		}
+		defer t.udp.Close()
+	}
+
+	go t.receive()
+
+	return t.run(ctx), nil
+}
+
+// run sends the rounds of probes, and returns the statistics of the hops up to the destination
+func (t *tracer) run(ctx context.Context) []Hop {
+	last := t.opts.MaxHops
+	ips := make([]net.IP, last+1)
+	rtts := make([][]float64, last+1)
+	sent := make([]int, last+1)
+
+	seq := 0
+	for round := 0; round < t.opts.Count && ctx.Err() == nil; round++ {
+		type probe struct {
+			ttl  int
+			sent time.Time
+		}
+		probes := make(map[int]probe, last)
+		for ttl := 1; ttl <= last; ttl++ {
+			seq++
+			probes[seq] = probe{ttl, time.Now()}
+			sent[ttl]++
+			if err := t.send(ctx, ttl, seq); err != nil {
+				log.Debugf("Failed to send probe with TTL %d: %s", ttl, err)
+			}
+		}
+
+		timeout := time.After(t.opts.Timeout)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-timeout:
+				break wait
+			case reply := <-t.replies:
+				p, ok := probes[reply.seq]
+				if !ok {
+					// a late reply to a previous round
+					continue
+				}
+				delete(probes, reply.seq)
+
+				if ips[p.ttl] == nil {
+					ips[p.ttl] = reply.from
+				}
+				rtts[p.ttl] = append(rtts[p.ttl], toMilliseconds(reply.at.Sub(p.sent)))
+				if reply.reached && p.ttl < last {
+					last = p.ttl
+				}
+			}
+		}
+	}
+
+	var hops []Hop
+	for ttl := 1; ttl <= last; ttl++ {
+		if sent[ttl] == 0 {
+			break
+		}
+
+		stats := getLatencyStats(rtts[ttl], sent[ttl], JitterLegacy)
+		hop := Hop{
+			TTL:      ttl,
+			Sent:     stats.Sent,
+			Received: stats.Received,
+			Loss:     math.Round(stats.Loss*100) / 100,
+			Avg:      math.Round(stats.Avg*1000) / 1000,
+			Min:      math.Round(stats.Min*1000) / 1000,
+			Max:      math.Round(stats.Max*1000) / 1000,
+			StdDev:   math.Round(stats.StdDev*1000) / 1000,
+		}
+		if ips[ttl] != nil {
+			hop.IP = ips[ttl].String()
+		}
+		hops = append(hops, hop)
+	}
+
+	if !t.opts.LookupASN {
+		return hops
+	}
+
+	// the AS of each hop is looked up concurrently
+	var wg sync.WaitGroup
+	for i := range hops {
+		if hops[i].IP == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(hop *Hop) {
+			defer wg.Done()
+			if backbone := LookupBackbone(ctx, hop.IP); backbone != nil {
+				hop.Backbone = backbone.String()
+			}
+		}(&hops[i])
+	}
+	wg.Wait()
+
+	return hops
+}
+
+// send sends the probe of sequence number `seq` with the given TTL
+func (t *tracer) send(ctx context.Context, ttl, seq int) error {
+	switch t.opts.Protocol {
+	case UDP:
+		var err error
+		if t.v6 {
+			err = ipv6.NewPacketConn(t.udp).SetHopLimit(ttl)
+		} else {
+			err = ipv4.NewPacketConn(t.udp).SetTTL(ttl)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = t.udp.WriteTo([]byte(ProgName), &net.UDPAddr{IP: t.dst, Port: traceBasePort + seq})
+		return err
+	case TCP:
+		go t.connect(ctx, ttl, seq)
+		return nil
+	default:
+		msg := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: t.id, Seq: seq, Data: []byte(ProgName)},
+		}
+		var err error
+		if t.v6 {
+			msg.Type = ipv6.ICMPTypeEchoRequest
+			err = t.conn.IPv6PacketConn().SetHopLimit(ttl)
+		} else {
+			err = t.conn.IPv4PacketConn().SetTTL(ttl)
+		}
+		if err != nil {
+			return err
+		}
+
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		_, err = t.conn.WriteTo(b, &net.IPAddr{IP: t.dst})
+		return err
+	}
+}
+
+// connect makes a TCP handshake to the destination with the given TTL, which is a reply from the destination if it
+// is accepted or refused. The socket is bound to an ephemeral port before connecting, which is recorded to match the
+// ICMP replies quoting it
+func (t *tracer) connect(ctx context.Context, ttl, seq int) {
+	dialer := net.Dialer{
+		Timeout: t.opts.Timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var errSock error
+			err := c.Control(func(fd uintptr) {
+				if errSock = setTTL(fd, ttl, t.v6); errSock != nil {
+					return
+				}
+				var port int
+				if port, errSock = bindPort(fd, net.ParseIP(t.opts.Source), t.v6); errSock == nil {
+					t.lock.Lock()
+					t.ports[port] = seq
+					t.lock.Unlock()
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return errSock
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(t.dst.String(), fmt.Sprint(t.port)))
+	at := time.Now()
+	if err == nil {
+		conn.Close()
+	} else if !errors.Is(err, syscall.ECONNREFUSED) {
+		log.Debugf("TCP probe with TTL %d got no answer from the destination: %s", ttl, err)
+		return
+	}
+
+	select {
+	case t.replies <- traceReply{seq: seq, from: t.dst, at: at, reached: true}:
+	default:
+	}
+}
+
+// receive reads the ICMP replies until the connection is closed
+func (t *tracer) receive() {
+	proto := 1
+	if t.v6 {
+		proto = 58
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		from := peer.(*net.IPAddr).IP
+
+		reply := traceReply{from: from, at: at}
+		var ok bool
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if (msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply) && t.opts.Protocol == ICMP && body.ID == t.id {
+				reply.seq, reply.reached, ok = body.Seq, true, true
+			}
+		case *icmp.TimeExceeded:
+			reply.seq, ok = t.match(body.Data)
+		case *icmp.DstUnreach:
+			reply.seq, ok = t.match(body.Data)
+			reply.reached = from.Equal(t.dst)
+		}
+
+		if ok {
+			select {
+			case t.replies <- reply:
+			default:
+			}
+		}
+	}
+}
+
+// sockaddr returns the socket address of the IP and port, the IP being the unspecified address if nil
+func sockaddr(ip net.IP, port int, v6 bool) syscall.Sockaddr {
+	if v6 {
+		addr := &syscall.SockaddrInet6{Port: port}
+		copy(addr.Addr[:], ip.To16())
+		return addr
+	}
+	addr := &syscall.SockaddrInet4{Port: port}
+	copy(addr.Addr[:], ip.To4())
+	return addr
+}
+
+// sockaddrPort returns the port of a socket address
+func sockaddrPort(sa syscall.Sockaddr) (int, error) {
+	switch addr := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return addr.Port, nil
+	case *syscall.SockaddrInet6:
+		return addr.Port, nil
+	default:
+		return 0, fmt.Errorf("unexpected socket address %v", sa)
+	}
+}
+
+// match returns the sequence number of the probe quoted by an ICMP error message
+func (t *tracer) match(data []byte) (int, bool) {
+	var proto byte
+	var dst net.IP
+	var payload []byte
+	if t.v6 {
+		if len(data) < 48 {
+			return 0, false
+		}
+		proto, dst, payload = data[6], data[24:40], data[40:]
+	} else {
+		if len(data) < 20 {
+			return 0, false
+		}
+		hl := int(data[0]&0x0f) * 4
+		if len(data) < hl+8 {
+			return 0, false
+		}
+		proto, dst, payload = data[9], data[16:20], data[hl:]
+	}
+	if !dst.Equal(t.dst) {
+		return 0, false
+	}
+
+	switch t.opts.Protocol {
+	case UDP:
+		if proto != syscall.IPPROTO_UDP {
+			return 0, false
+		}
+		port := int(binary.BigEndian.Uint16(payload[2:4]))
+		if port <= traceBasePort {
+			return 0, false
+		}
+		return port - traceBasePort, true
+	case TCP:
+		if proto != syscall.IPPROTO_TCP {
+			return 0, false
+		}
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		seq, ok := t.ports[int(binary.BigEndian.Uint16(payload[0:2]))]
+		return seq, ok
+	default:
+		if (t.v6 && proto != 58) || (!t.v6 && proto != 1) {
+			return 0, false
+		}
+		if int(binary.BigEndian.Uint16(payload[4:6])) != t.id {
+			return 0, false
+		}
+		return int(binary.BigEndian.Uint16(payload[6:8])), true
+	}
+}
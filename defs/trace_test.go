@@ -0,0 +1,70 @@
+package defs
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// quoted returns the IPv4 header and first 8 bytes of a probe to dst, as quoted by an ICMP error message
+func quoted(proto byte, dst net.IP, payload [8]byte) []byte {
+	data := make([]byte, 28)
+	data[0] = 0x45
+	data[9] = proto
+	copy(data[16:20], dst.To4())
+	copy(data[20:], payload[:])
+	return data
+}
+
+func TestTraceMatch(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	other := net.ParseIP("192.0.2.2")
+
+	udp := func(port uint16) (p [8]byte) {
+		binary.BigEndian.PutUint16(p[2:4], port)
+		return
+	}
+	tcp := func(port uint16) (p [8]byte) {
+		binary.BigEndian.PutUint16(p[0:2], port)
+		return
+	}
+	echo := func(id, seq uint16) (p [8]byte) {
+		binary.BigEndian.PutUint16(p[4:6], id)
+		binary.BigEndian.PutUint16(p[6:8], seq)
+		return
+	}
+
+	tests := []struct {
+		name     string
+		protocol PingType
+		data     []byte
+		wantSeq  int
+		wantOK   bool
+	}{
+		{"UDP probe", UDP, quoted(syscall.IPPROTO_UDP, dst, udp(traceBasePort+5)), 5, true},
+		{"UDP probe to a lower port", UDP, quoted(syscall.IPPROTO_UDP, dst, udp(53)), 0, false},
+		{"UDP probe to another destination", UDP, quoted(syscall.IPPROTO_UDP, other, udp(traceBasePort+5)), 0, false},
+		{"TCP probe", TCP, quoted(syscall.IPPROTO_TCP, dst, tcp(40000)), 7, true},
+		{"TCP probe from an unknown port", TCP, quoted(syscall.IPPROTO_TCP, dst, tcp(traceBasePort+7)), 0, false},
+		{"TCP probe quoted as UDP", TCP, quoted(syscall.IPPROTO_UDP, dst, tcp(40000)), 0, false},
+		{"ICMP probe", ICMP, quoted(1, dst, echo(42, 3)), 3, true},
+		{"ICMP probe of another process", ICMP, quoted(1, dst, echo(43, 3)), 0, false},
+		{"truncated", ICMP, quoted(1, dst, echo(42, 3))[:24], 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &tracer{
+				opts:  TraceOptions{Protocol: tt.protocol},
+				dst:   dst,
+				id:    42,
+				ports: map[int]int{40000: 7},
+			}
+			seq, ok := tr.match(tt.data)
+			if seq != tt.wantSeq || ok != tt.wantOK {
+				t.Errorf("match() = %d, %t; want %d, %t", seq, ok, tt.wantSeq, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package defs
+
+import (
+	"net"
+	"syscall"
+)
+
+// setTTL sets the TTL (hop limit for IPv6) of the packets sent by the socket
+func setTTL(fd uintptr, ttl int, v6 bool) error {
+	if v6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// bindPort binds the socket to the IP, or to any if nil, and to an ephemeral port, which is returned
+func bindPort(fd uintptr, ip net.IP, v6 bool) (int, error) {
+	if err := syscall.Bind(int(fd), sockaddr(ip, 0, v6)); err != nil {
+		return 0, err
+	}
+	sa, err := syscall.Getsockname(int(fd))
+	if err != nil {
+		return 0, err
+	}
+	return sockaddrPort(sa)
+}
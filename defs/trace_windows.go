@@ -0,0 +1,21 @@
+package defs
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setTTL sets the TTL (hop limit for IPv6) of the packets sent by the socket
+func setTTL(fd uintptr, ttl int, v6 bool) error {
+	if v6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// bindPort is not supported on Windows, where the sockets are bound by the runtime after the dialer's Control
+// function, so the port of a TCP probe cannot be known before it is sent
+func bindPort(fd uintptr, ip net.IP, v6 bool) (int, error) {
+	return 0, errors.New("TCP path analysis probes are not supported on Windows")
+}
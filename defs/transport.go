@@ -0,0 +1,339 @@
+package defs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+const (
+	ProtoHTTP1 = "HTTP/1.1"
+	ProtoHTTP2 = "HTTP/2.0"
+	ProtoHTTP3 = "HTTP/3.0"
+	// ProtoMixed is the protocol of a test whose requests were made over several protocols
+	ProtoMixed = "mixed"
+)
+
+const (
+	// maxProtocolFailures is the number of consecutive failed requests to a host after which its requests are made over
+	// HTTP/1.1, failures to negotiate the protocol switch to it at once
+	maxProtocolFailures = 3
+	// quicNoApplicationProtocol is the QUIC error carrying the TLS no_application_protocol alert, sent by servers which
+	// support none of the protocols offered with ALPN
+	quicNoApplicationProtocol quic.TransportErrorCode = 0x100 + 120
+)
+
+// errNotNegotiated is returned when the server does not agree on the preferred protocol
+var errNotNegotiated = errors.New("protocol not negotiated")
+
+// quicHandshakeTimeout is the time allowed for the QUIC handshake, after which the server is assumed not to support
+// HTTP/3, e.g. as UDP is blocked
+var quicHandshakeTimeout = 3 * time.Second
+
+// NewTransferClient returns the HTTP client used by download and upload tests, which makes the requests over the given
+// protocol (`h1`, `h2` or `h3`) where the server supports it. It is based on `base`, the transport of the default HTTP
+// client, so the same source address, interface and IP version binding apply, with `dialer` and `network` being used to
+// bind the UDP socket of HTTP/3. The returned closer releases the connections and the UDP socket once the tests are done
+func NewTransferClient(protocol string, base *http.Transport, dialer *net.Dialer, network string) (*http.Client, io.Closer, error) {
+	h1 := base.Clone()
+	h1.ForceAttemptHTTP2 = false
+	h1.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	// cloning sets up HTTP/2 on base first, whose ALPN protocols would be copied
+	if h1.TLSClientConfig != nil {
+		h1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	transport := &protocolTransport{fallback: h1}
+	switch protocol {
+	case "h1":
+	case "h2":
+		dial := base.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+
+		// h2 is negotiated with ALPN over TLS, and assumed with prior knowledge (h2c) over cleartext
+		h2 := &http2.Transport{
+			TLSClientConfig: base.TLSClientConfig,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				// HTTP/1.1 is offered too, so that the servers without HTTP/2 complete the handshake rather than failing it
+				// with an alert, and are told apart by the negotiated protocol
+				if !slices.Contains(cfg.NextProtos, "http/1.1") {
+					cfg = cfg.Clone()
+					cfg.NextProtos = append(cfg.NextProtos, "http/1.1")
+				}
+				tlsConn := tls.Client(conn, cfg)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				// the ALPN check of http2.Transport is skipped for custom dialers
+				if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+					conn.Close()
+					return nil, fmt.Errorf("%w: server selected %q", errNotNegotiated, proto)
+				}
+				return tlsConn, nil
+			},
+		}
+		h2c := &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return &h2cConn{Conn: conn}, nil
+			},
+		}
+
+		transport.proto = ProtoHTTP2
+		transport.preferred = map[string]http.RoundTripper{"https": h2, "http": h2c}
+	case "h3":
+		udpNetwork := "udp"
+		switch network {
+		case "ip4":
+			udpNetwork = "udp4"
+		case "ip6":
+			udpNetwork = "udp6"
+		}
+
+		laddr := &net.UDPAddr{}
+		lc := net.ListenConfig{}
+		if dialer != nil {
+			lc.Control = dialer.Control
+			if addr, ok := dialer.LocalAddr.(*net.TCPAddr); ok {
+				laddr.IP = addr.IP
+			}
+		}
+		conn, err := lc.ListenPacket(context.Background(), udpNetwork, laddr.String())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to bind UDP socket for HTTP/3: %w", err)
+		}
+		qt := &quic.Transport{Conn: conn}
+
+		// HTTP/3 is only available over TLS, cleartext requests use the fallback
+		h3 := &http3.Transport{
+			TLSClientConfig: base.TLSClientConfig,
+			QUICConfig:      &quic.Config{HandshakeIdleTimeout: quicHandshakeTimeout},
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+				raddr, err := net.ResolveUDPAddr(udpNetwork, addr)
+				if err != nil {
+					return nil, err
+				}
+				conn, err := qt.DialEarly(ctx, raddr, tlsCfg, cfg)
+				var idleErr *quic.IdleTimeoutError
+				if errors.As(err, &idleErr) {
+					return nil, fmt.Errorf("%w: no answer to the QUIC handshake: %w", errNotNegotiated, err)
+				}
+				return conn, err
+			},
+		}
+
+		transport.proto = ProtoHTTP3
+		transport.preferred = map[string]http.RoundTripper{"https": h3}
+		// the QUIC transport does not close the socket it was given
+		transport.close = func() error {
+			h3.Close()
+			qt.Close()
+			return conn.Close()
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown protocol: %s", protocol)
+	}
+
+	return &http.Client{Transport: transport, Timeout: http.DefaultClient.Timeout}, transport, nil
+}
+
+// protocolTransport makes requests with the preferred transport of the URL scheme, and falls back to HTTP/1.1 for the
+// schemes without one and for the hosts not supporting it, retrying the request which found it out. A host is known not
+// to support the preferred protocol once it could not be negotiated, or after maxProtocolFailures failed requests in a
+// row
+type protocolTransport struct {
+	proto     string
+	preferred map[string]http.RoundTripper
+	fallback  http.RoundTripper
+
+	// close releases the resources of the preferred transports besides their idle connections
+	close func() error
+
+	lock        sync.Mutex
+	failures    map[string]int
+	unsupported map[string]bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *protocolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	preferred, ok := t.preferred[req.URL.Scheme]
+
+	t.lock.Lock()
+	skip := t.unsupported[req.URL.Host]
+	t.lock.Unlock()
+
+	if !ok || skip {
+		return t.fallback.RoundTrip(req)
+	}
+
+	// connection-specific headers such as `Connection: close` are not allowed over HTTP/2 and HTTP/3
+	r := req
+	if req.Header.Get("Connection") != "" {
+		r = req.Clone(req.Context())
+		r.Header.Del("Connection")
+	}
+
+	resp, err := preferred.RoundTrip(r)
+	if err == nil {
+		t.lock.Lock()
+		delete(t.failures, req.URL.Host)
+		t.lock.Unlock()
+		return resp, nil
+	}
+	if req.Context().Err() != nil || !t.failed(req.URL.Host, err) {
+		return resp, err
+	}
+
+	// the body may have been consumed by the failed request, so it can only be retried with a new one
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, err
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return resp, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	return t.fallback.RoundTrip(req)
+}
+
+// Close closes the idle connections of the transports and releases their resources, see NewTransferClient
+func (t *protocolTransport) Close() error {
+	type idleCloser interface{ CloseIdleConnections() }
+	for _, rt := range t.preferred {
+		if c, ok := rt.(idleCloser); ok {
+			c.CloseIdleConnections()
+		}
+	}
+	if c, ok := t.fallback.(idleCloser); ok {
+		c.CloseIdleConnections()
+	}
+
+	if t.close != nil {
+		return t.close()
+	}
+	return nil
+}
+
+// failed counts a failed request to host, and reports whether the host is known not to support the preferred protocol
+// from now on
+func (t *protocolTransport) failed(host string, err error) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.unsupported[host] {
+		return true
+	}
+	if t.failures == nil {
+		t.failures = make(map[string]int)
+		t.unsupported = make(map[string]bool)
+	}
+
+	t.failures[host]++
+	switch {
+	case negotiationFailed(err):
+		log.Warnf("%s is not supported by %s, will use %s: %s", t.proto, host, ProtoHTTP1, err)
+	case t.failures[host] >= maxProtocolFailures:
+		log.Warnf("%s requests to %s failed %d times in a row, will use %s: %s", t.proto, host, t.failures[host], ProtoHTTP1, err)
+	default:
+		log.Debugf("%s request to %s failed: %s", t.proto, host, err)
+		return false
+	}
+	t.unsupported[host] = true
+	return true
+}
+
+// negotiationFailed reports whether the error shows that the server does not support the preferred protocol, rather
+// than a failure of the network or of the request
+func negotiationFailed(err error) bool {
+	var transportErr *quic.TransportError
+	var versionErr *quic.VersionNegotiationError
+	var timeoutErr *quic.HandshakeTimeoutError
+	switch {
+	case errors.Is(err, errNotNegotiated), errors.As(err, &versionErr), errors.As(err, &timeoutErr):
+		return true
+	case errors.As(err, &transportErr):
+		return transportErr.ErrorCode == quicNoApplicationProtocol
+	}
+	return false
+}
+
+// h2cConn is a cleartext connection over which HTTP/2 is assumed with prior knowledge. The servers not supporting it
+// answer the connection preface over HTTP/1.1 or close the connection, so the reads and writes failing before anything
+// is received fail with errNotNegotiated
+type h2cConn struct {
+	net.Conn
+	received atomic.Bool
+}
+
+// Read implements io.Reader
+func (c *h2cConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.received.Load() {
+		if (n == 0 && err != nil) || bytes.HasPrefix(p[:n], []byte("HTTP/")) {
+			return 0, fmt.Errorf("%w: server did not answer the HTTP/2 connection preface", errNotNegotiated)
+		}
+		c.received.Store(n > 0)
+	}
+	return n, err
+}
+
+// Write implements io.Writer
+func (c *h2cConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if err != nil && !c.received.Load() {
+		return n, fmt.Errorf("%w: server closed the connection before answering the HTTP/2 connection preface: %w", errNotNegotiated, err)
+	}
+	return n, err
+}
+
+// transferProtocol returns the protocol of a test from the number of responses received over each protocol, which is
+// ProtoMixed if they were received over several ones
+func transferProtocol(counts map[string]int) string {
+	protos := make([]string, 0, len(counts))
+	for proto := range counts {
+		protos = append(protos, proto)
+	}
+	switch len(protos) {
+	case 0:
+		return ""
+	case 1:
+		return protos[0]
+	}
+
+	slices.Sort(protos)
+	for i, proto := range protos {
+		protos[i] = fmt.Sprintf("%s (%d)", proto, counts[proto])
+	}
+	log.Warnf("Requests were made over several protocols: %s", strings.Join(protos, ", "))
+	return ProtoMixed
+}
@@ -0,0 +1,209 @@
+package defs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestProtocolFallback(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+		io.Copy(w, r.Body)
+	})
+
+	tests := []struct {
+		name      string
+		tls       bool
+		h2        bool
+		body      string
+		getBody   bool
+		wantProto string
+		wantErr   bool
+	}{
+		{"h2c supported", false, true, "", false, ProtoHTTP2, false},
+		{"h2c unsupported", false, false, "", false, ProtoHTTP1, false},
+		{"h2c unsupported with a replayable body", false, false, "payload", true, ProtoHTTP1, false},
+		{"h2c unsupported with a body", false, false, "payload", false, "", true},
+		{"h2 supported", true, true, "payload", true, ProtoHTTP2, false},
+		{"h2 unsupported", true, false, "payload", true, ProtoHTTP1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(echo)
+			if tt.tls {
+				srv.EnableHTTP2 = tt.h2
+				srv.StartTLS()
+			} else {
+				if tt.h2 {
+					srv.Config.Handler = h2c.NewHandler(echo, &http2.Server{})
+				}
+				srv.Start()
+			}
+			defer srv.Close()
+
+			client, closer, err := NewTransferClient("h2", srv.Client().Transport.(*http.Transport), nil, "")
+			if err != nil {
+				t.Fatalf("NewTransferClient() error = %v", err)
+			}
+			defer closer.Close()
+
+			req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+			if tt.body != "" {
+				req.Body = io.NopCloser(strings.NewReader(tt.body))
+				if tt.getBody {
+					req.GetBody = func() (io.ReadCloser, error) {
+						return io.NopCloser(strings.NewReader(tt.body)), nil
+					}
+				}
+			}
+			req.Header.Set("Connection", "close")
+
+			resp, err := client.Do(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if proto := resp.Header.Get("X-Proto"); proto != tt.wantProto || resp.Proto != tt.wantProto {
+				t.Errorf("served over %s, received over %s, want %s", proto, resp.Proto, tt.wantProto)
+			}
+			if !bytes.Equal(body, []byte(tt.body)) {
+				t.Errorf("echoed %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestHTTP3Fallback(t *testing.T) {
+	defer func(timeout time.Duration) { quicHandshakeTimeout = timeout }(quicHandshakeTimeout)
+	quicHandshakeTimeout = 200 * time.Millisecond
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	})
+
+	tests := []struct {
+		name string
+		// quic is the ALPN protocol of the QUIC server on the port of the TLS server, none if empty
+		quic      string
+		wantProto string
+	}{
+		{"h3 supported", http3.NextProtoH3, ProtoHTTP3},
+		{"QUIC without h3", "other", ProtoHTTP1},
+		{"QUIC unavailable", "", ProtoHTTP1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(echo)
+			defer srv.Close()
+
+			switch tt.quic {
+			case "":
+			case http3.NextProtoH3:
+				conn, err := net.ListenPacket("udp", srv.Listener.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				h3 := &http3.Server{Handler: echo, TLSConfig: http3.ConfigureTLSConfig(srv.TLS)}
+				go h3.Serve(conn)
+				defer h3.Close()
+			default:
+				ln, err := quic.ListenAddr(srv.Listener.Addr().String(), &tls.Config{Certificates: srv.TLS.Certificates, NextProtos: []string{tt.quic}}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ln.Close()
+			}
+
+			client, closer, err := NewTransferClient("h3", srv.Client().Transport.(*http.Transport), nil, "ip")
+			if err != nil {
+				t.Fatalf("NewTransferClient() error = %v", err)
+			}
+			defer closer.Close()
+
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			resp.Body.Close()
+			if proto := resp.Header.Get("X-Proto"); proto != tt.wantProto || resp.Proto != tt.wantProto {
+				t.Errorf("served over %s, received over %s, want %s", proto, resp.Proto, tt.wantProto)
+			}
+		})
+	}
+}
+
+func TestProtocolFailures(t *testing.T) {
+	errReset := errors.New("connection reset by peer")
+	tests := []struct {
+		name string
+		errs []error
+		want []bool
+	}{
+		{"not negotiated", []error{fmt.Errorf("%w: server selected %q", errNotNegotiated, "http/1.1")}, []bool{true}},
+		{"no application protocol", []error{&quic.TransportError{ErrorCode: quicNoApplicationProtocol}}, []bool{true}},
+		{"QUIC handshake timeout", []error{&quic.HandshakeTimeoutError{}}, []bool{true}},
+		{"QUIC version", []error{&quic.VersionNegotiationError{}}, []bool{true}},
+		{"transient", []error{errReset, &quic.IdleTimeoutError{}}, []bool{false, false}},
+		{"consecutive", []error{errReset, errReset, errReset, errReset}, []bool{false, false, true, true}},
+		{"reset by a success", []error{errReset, errReset, nil, errReset, errReset}, []bool{false, false, false, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &protocolTransport{proto: ProtoHTTP2}
+			for i, err := range tt.errs {
+				if err == nil {
+					delete(transport.failures, "example.com")
+					continue
+				}
+				if got := transport.failed("example.com", err); got != tt.want[i] {
+					t.Errorf("failed() #%d = %t, want %t", i, got, tt.want[i])
+				}
+			}
+			if transport.failed("example.net", errReset) {
+				t.Errorf("failed() on another host = true, want false")
+			}
+		})
+	}
+}
+
+func TestTransferProtocol(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   string
+	}{
+		{"no response", nil, ""},
+		{"single protocol", map[string]int{ProtoHTTP3: 12}, ProtoHTTP3},
+		{"several protocols", map[string]int{ProtoHTTP2: 6, ProtoHTTP1: 6}, ProtoMixed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transferProtocol(tt.counts); got != tt.want {
+				t.Errorf("transferProtocol(%v) = %q, want %q", tt.counts, got, tt.want)
+			}
+		})
+	}
+}
@@ -54,6 +54,12 @@ func main() {
 				Aliases: []string{defs.OptionIPv6Alt},
 				Usage:   "Force IPv6 only",
 			},
+			&cli.BoolFlag{
+				Name: defs.OptionDual,
+				Usage: "Test each server over IPv4 and then over IPv6, and\n" +
+					"\tcompare the results side by side. Only servers with\n" +
+					"\tboth addresses are used\n\t",
+			},
 			&cli.BoolFlag{
 				Name:   defs.OptionNoDownload,
 				Usage:  "Do not perform download test",
@@ -67,18 +73,21 @@ func main() {
 			&cli.StringFlag{
 				Name:    defs.OptionPingType,
 				Aliases: []string{defs.OptionPingTypeAlt},
-				Usage: "Change ping `TYPE`. Can be `icmp`, `udp` or `http`.\n" +
+				Usage: "Change ping `TYPE`. Can be `icmp`, `udp`, `tcp` or `http`.\n" +
 					"\tFor Linux user, icmp ping needs you run as root or give\n" +
 					"\t`cap_net_raw` capability, unprivileged UDP ping needs you\n" +
 					"\tset `net.ipv4.ping_group_range` parameter cover all groups.\n" +
-					"\tFor Windows user, udp ping is not available\n\t",
+					"\tFor Windows user, udp ping is not available. tcp ping\n" +
+					"\tonly measures the handshake to the server port and\n" +
+					"\tneeds no privilege\n\t",
 				Value: "icmp",
 			},
-			&cli.IntFlag{
+			&cli.StringFlag{
 				Name:    defs.OptionConcurrent,
 				Aliases: []string{defs.OptionConcurrentAlt},
-				Usage:   "Concurrent HTTP requests being made",
-				Value:   3,
+				Usage: "Concurrent HTTP requests being made, or `auto` to add\n" +
+					"\tconnections until the throughput stops rising",
+				Value: "3",
 			},
 			&cli.IntFlag{
 				Name:    defs.OptionPingCount,
@@ -86,6 +95,18 @@ func main() {
 				Usage:   "Count of ICMP or HTTP ping packets to send",
 				Value:   5,
 			},
+			&cli.StringFlag{
+				Name: defs.OptionJitter,
+				Usage: "Jitter `ALGORITHM`. Can be `legacy` (smoothed by\n" +
+					"\tweighting the previous value), `rfc3550` (interarrival\n" +
+					"\tjitter) or `msd` (mean absolute successive difference)\n\t",
+				Value: "legacy",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionNoBufferbloat,
+				Usage: "Do not measure latency during download and upload\n" +
+					"\ttests, which is used to grade bufferbloat",
+			},
 			&cli.BoolFlag{
 				Name: defs.OptionBytes,
 				Usage: "Display values in bytes instead of bits. Does not affect\n" +
@@ -119,10 +140,154 @@ func main() {
 				Usage: "Suppress verbose output. Speeds listed in bit/s and not\n" +
 					"\taffected by --bytes",
 			},
+			&cli.StringFlag{
+				Name: defs.OptionFormat,
+				Usage: "Output `FORMAT` of the results. Can be `influx` (InfluxDB\n" +
+					"\tline protocol), `graphite` (Graphite plaintext with\n" +
+					"\ttags) or `jsonl` (JSON lines of the events streamed\n" +
+					"\twhile testing). Suppresses verbose output unless\n" +
+					"\t--output is given",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionOutput,
+				Usage: "Write --format output to `URL` instead of stdout. Can be\n" +
+					"\ttcp://HOST:PORT, udp://HOST:PORT or an http(s):// URL\n" +
+					"\tthe results are posted to",
+			},
+			&cli.StringSliceFlag{
+				Name: defs.OptionOutputHeader,
+				Usage: "Add a `HEADER` to the requests to an http(s) --output,\n" +
+					"\te.g. \"Authorization: Token ...\". Can be supplied\n" +
+					"\tmultiple times\n\t",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionExporter,
+				Usage: "Run as a Prometheus exporter serving /metrics on\n" +
+					"\t`ADDRESS`, testing the servers selected at start",
+			},
+			&cli.IntFlag{
+				Name: defs.OptionExporterEvery,
+				Usage: "Test every `SECONDS` in exporter mode, 0 to test when\n" +
+					"\tscraped. Scrape timeout has to cover a test then",
+			},
+			&cli.IntFlag{
+				Name: defs.OptionExporterMin,
+				Usage: "Minimum `SECONDS` between tests in exporter mode, the\n" +
+					"\tresults of the last test are served until then. The\n" +
+					"\tdefault only applies when testing on scrape",
+				Value: 300,
+			},
+			&cli.Float64Flag{
+				Name: defs.OptionMinDownload,
+				Usage: "Fail with exit code 10 if the download speed is below\n" +
+					"\t`MBPS`",
+			},
+			&cli.Float64Flag{
+				Name:  defs.OptionMinUpload,
+				Usage: "Fail with exit code 11 if the upload speed is below `MBPS`",
+			},
+			&cli.Float64Flag{
+				Name:  defs.OptionMaxPing,
+				Usage: "Fail with exit code 12 if the latency is above `MS`",
+			},
+			&cli.Float64Flag{
+				Name:  defs.OptionMaxJitter,
+				Usage: "Fail with exit code 13 if the jitter is above `MS`",
+			},
+			&cli.Float64Flag{
+				Name: defs.OptionMaxLoss,
+				Usage: "Fail with exit code 14 if the packet loss is above\n" +
+					"\t`PERCENT`",
+			},
+			&cli.Float64Flag{
+				Name: defs.OptionMaxRegression,
+				Usage: "Fail with exit code 15 (download) or 16 (upload) if the\n" +
+					"\tspeed is more than `PERCENT` below the mean of the\n" +
+					"\tlast --baseline-runs results of the server in the\n" +
+					"\thistory",
+			},
+			&cli.IntFlag{
+				Name:  defs.OptionBaselineRuns,
+				Usage: "Number of `RUNS` in the history the baseline is made of",
+				Value: 10,
+			},
+			&cli.StringFlag{
+				Name: defs.OptionCheckSummary,
+				Usage: "Write the JSON summary of the threshold checks to `FILE`\n" +
+					"\tinstead of stderr, - for stdout. The code of the first\n" +
+					"\tfailed check in the order above is the exit code, or\n" +
+					"\t17 if a selected server has no result\n\t",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionTimings,
+				Usage: "Include the DNS, TCP connect, TLS handshake and time to\n" +
+					"\tfirst byte of the HTTP requests in --json output",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionTrace,
+				Usage: "Analyse the path to the server like mtr after the test.\n" +
+					"\tRequires root or cap_net_raw to read the ICMP replies",
+			},
+			&cli.BoolFlag{
+				Name:  defs.OptionTraceOnly,
+				Usage: "Only analyse the path to the server, skip the test",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionTraceProtocol,
+				Usage: "`TYPE` of path analysis probes. Can be `icmp`, `udp` or `tcp`",
+				Value: "icmp",
+			},
+			&cli.IntFlag{
+				Name: defs.OptionTraceCount,
+				Usage: "`NUMBER` of probes sent to each hop by path analysis.\n" +
+					"\tAt most 32101 probes are sent to all the hops",
+				Value: 10,
+			},
+			&cli.IntFlag{
+				Name:  defs.OptionTraceHops,
+				Usage: "Maximum `NUMBER` of hops of path analysis",
+				Value: 30,
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionTraceASN,
+				Usage: "Map the hops of path analysis to ISP backbones by their\n" +
+					"\tAS. The hop addresses leave the host: they are looked up\n" +
+					"\tin the DNS zone of Team Cymru (origin.asn.cymru.com)\n\t",
+			},
 			&cli.BoolFlag{
 				Name:    defs.OptionList,
 				Aliases: []string{defs.OptionListAlt},
-				Usage:   "Display a list of servers",
+				Usage: "Display a list of servers, with every field in CSV or\n" +
+					"\tJSON if --csv or --json is given",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionListProvince,
+				Usage: "Only list the servers in `PROVINCE`, given as its ID,\n" +
+					"\tcode or name",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionListISP,
+				Usage: "Only list the servers of `ISP`, given as its ID, ASN,\n" +
+					"\tcode or name",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionListType,
+				Usage: "Only list the servers of `TYPE`. Can be `globalspeed`,\n" +
+					"\t`perception`, `wirelessspeed` or `staticfile`",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionListStack,
+				Usage: "Only list the servers with an address of `STACK`. Can\n" +
+					"\tbe `ipv4`, `ipv6` or `dual` (both)",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionListName,
+				Usage: "Only list the servers whose name contains `TEXT`",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionListSort,
+				Usage: "Sort the list by `KEY`. Can be `id`, `name`, `province`,\n" +
+					"\t`city`, `isp` or `type`",
 			},
 			&cli.StringSliceFlag{
 				Name:    defs.OptionServer,
@@ -145,6 +310,40 @@ func main() {
 				Usage: "`EXCLUDE` a server from selection. Can be supplied\n" +
 					"\tmultiple times",
 			},
+			&cli.StringFlag{
+				Name: defs.OptionLocalServers,
+				Usage: "Load servers from a JSON or YAML `FILE` instead of\n" +
+					"\tthe Core API, with the same fields as the API's server\n" +
+					"\tlist. Works offline unless --merge-servers is given",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionMergeServers,
+				Usage: "Merge the servers of --local-servers with the Core API\n" +
+					"\tserver list, the local ones taking precedence\n\t",
+			},
+			&cli.IntFlag{
+				Name: defs.OptionCacheTTL,
+				Usage: "Reuse the server list cached on disk for `SECONDS`\n" +
+					"\tinstead of fetching it. The cached list is always used\n" +
+					"\tif the Core API cannot be reached",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionCacheDir,
+				Usage: "`DIRECTORY` of the server list cache",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionNoCache,
+				Usage: "Neither cache the server list nor use the cached one,\n" +
+					"\teven if the Core API cannot be reached",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionHistoryDir,
+				Usage: "`DIRECTORY` the results are recorded to",
+			},
+			&cli.BoolFlag{
+				Name:  defs.OptionNoHistory,
+				Usage: "Do not record the results to the history\n\t",
+			},
 			&cli.StringFlag{
 				Name: defs.OptionSource,
 				Usage: "`SOURCE` IP address to bind to, will not obey when\n" +
@@ -167,6 +366,46 @@ func main() {
 				Value:   15,
 				Hidden:  true,
 			},
+			&cli.IntFlag{
+				Name: defs.OptionInterval,
+				Usage: "Interval in milliseconds between throughput samples\n" +
+					"\trecorded during the test",
+				Value:  200,
+				Hidden: true,
+			},
+			&cli.StringFlag{
+				Name: defs.OptionWarmup,
+				Usage: "Warm-up period in `SECONDS` excluded from the reported\n" +
+					"\tspeed, or `auto` to exclude until the throughput\n" +
+					"\tstops rising\n\t",
+				Value: "0",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionSpeedMetric,
+				Usage: "Reported speed `METRIC`. Can be `mean` or `p90` (the\n" +
+					"\t90th percentile of the sampled throughput)\n\t",
+				Value: "mean",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionProtocol,
+				Usage: "HTTP `PROTOCOL` of the download and upload tests. Can\n" +
+					"\tbe `h1`, `h2` or `h3`. h2 is used over TLS and as h2c\n" +
+					"\tover cleartext, h3 over TLS only. Falls back to h1 for\n" +
+					"\tservers not supporting it. Concurrent requests share\n" +
+					"\tone connection with h2 and h3. The protocol is reported\n" +
+					"\tas `mixed` if the requests were made over several ones\n\t",
+				Value: "h1",
+			},
+			&cli.IntFlag{
+				Name: defs.OptionVolume,
+				Usage: "Stop each direction once `MB` of data has been moved\n" +
+					"\tinstead of after --duration",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionStopFirst,
+				Usage: "With --volume, stop at whichever of --duration or\n" +
+					"\t--volume is reached first\n\t",
+			},
 			&cli.IntFlag{
 				Name:   defs.OptionUploadSize,
 				Usage:  "Size of payload being uploaded in KiB",
@@ -210,6 +449,94 @@ func main() {
 				Hidden:  true,
 			},
 		},
+		Commands: []*cli.Command{
+			{
+				Name: "serve",
+				Usage: "Run a speed test server implementing the GlobalSpeed,\n" +
+					"\tPerception and WirelessSpeed endpoints",
+				Action: speedtest.Serve,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  defs.OptionListen,
+						Usage: "`ADDRESS` to listen on",
+						Value: ":8080",
+					},
+					&cli.StringSliceFlag{
+						Name: defs.OptionServeTypes,
+						Usage: "Server `TYPES` to serve, can be `globalspeed`,\n" +
+							"\t`perception` and `wirelessspeed`. All of them are\n" +
+							"\tserved by default",
+					},
+					&cli.IntFlag{
+						Name: defs.OptionMaxClients,
+						Usage: "Maximum `NUMBER` of GlobalSpeed tests queued at the\n" +
+							"\tsame time, 0 for no limit",
+					},
+					&cli.StringFlag{
+						Name: defs.OptionTLSCert,
+						Usage: "TLS certificate `FILE`, enables HTTPS with HTTP/2\n" +
+							"\tand HTTP/3",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionTLSKey,
+						Usage: "TLS private key `FILE`",
+					},
+					&cli.BoolFlag{
+						Name:  defs.OptionDebug,
+						Usage: "Log every request",
+					},
+				},
+			},
+			{
+				Name: "history",
+				Usage: "List the recorded results, or their statistics per day\n" +
+					"\tor hour",
+				Action: speedtest.History,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:    defs.OptionServer,
+						Aliases: []string{defs.OptionServerAlt},
+						Usage: "Only show the results of the server `ID`. Can be\n" +
+							"\tsupplied multiple times",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionISP,
+						Usage: "Only show the results of the servers of `ISP`",
+					},
+					&cli.StringFlag{
+						Name: defs.OptionSince,
+						Usage: "Only show the results from `TIME` on, e.g. 2006-01-02\n" +
+							"\tor \"2006-01-02 15:04\"",
+					},
+					&cli.StringFlag{
+						Name: defs.OptionUntil,
+						Usage: "Only show the results before `TIME`. A date is\n" +
+							"\tincluded as a whole",
+					},
+					&cli.StringFlag{
+						Name: defs.OptionAggregate,
+						Usage: "Show the mean, min and max of the results per\n" +
+							"\t`PERIOD`. Can be `day` or `hour`",
+					},
+					&cli.BoolFlag{
+						Name:  defs.OptionCSV,
+						Usage: "Export in CSV format",
+					},
+					&cli.BoolFlag{
+						Name:  defs.OptionJSON,
+						Usage: "Export in JSON format",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionHistoryDir,
+						Usage: "`DIRECTORY` the results are recorded to",
+					},
+					&cli.BoolFlag{
+						Name:  defs.OptionDebug,
+						Usage: "Turn on debug logging",
+					},
+				},
+			},
+		},
 	}
 
 	// run main function with cli options
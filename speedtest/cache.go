@@ -0,0 +1,130 @@
+package speedtest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// appDirName is the directory under the user's cache and data directories where the server lists are cached and the
+// history is recorded by default
+const appDirName = "taierspeed-cli"
+
+// cachedList represents a server list cached on disk
+type cachedList[T any] struct {
+	API       string    `json:"api"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Data      T         `json:"data"`
+}
+
+// cachedApiGet is apiGet for the server lists, which are cached on disk keyed by the API and the query, i.e. the stack,
+// province, ISP, city, servers and groups requested, together with the --api-header given. Every list fetched is
+// cached, which is used as a fallback when the Core API cannot be reached, and as is until it is older than --cache-ttl
+// if set. Nothing is cached or read from the cache with --no-cache
+func cachedApiGet[T []defs.Server | []defs.ServerResponse](c *cli.Context, path string, query url.Values) (T, error) {
+	if c.Bool(defs.OptionNoCache) {
+		return apiGet[T](c, path, query)
+	}
+
+	api := c.String(defs.OptionAPIBase) + "/" + c.String(defs.OptionAPIVersion) + "/" + path
+	if query != nil {
+		api += "?" + query.Encode()
+	}
+	file := cacheFile(c, api)
+
+	cached, errCache := readCache[T](file)
+	if errCache == nil {
+		age := time.Since(cached.FetchedAt)
+		if ttl := time.Duration(c.Int(defs.OptionCacheTTL)) * time.Second; age >= 0 && age < ttl {
+			log.Debugf("Using server list cached %s ago", age.Round(time.Second))
+			return cached.Data, nil
+		}
+	} else if !os.IsNotExist(errCache) {
+		log.Debugf("Failed to read cached server list: %s", errCache)
+	}
+
+	data, err := apiGet[T](c, path, query)
+	if err != nil {
+		if errCache != nil {
+			return data, err
+		}
+		log.Warnf("Error when fetching server list: %s", err)
+		log.Warnf("Using stale server list cached at %s", cached.FetchedAt.Local().Format(time.DateTime))
+		return cached.Data, nil
+	}
+
+	if err := writeCache(file, cachedList[T]{API: api, FetchedAt: time.Now(), Data: data}); err != nil {
+		log.Debugf("Failed to cache server list: %s", err)
+	}
+
+	return data, nil
+}
+
+// cacheFile returns the path of the cache file of the given API request, which differs by the headers sent with it
+func cacheFile(c *cli.Context, api string) string {
+	dir := c.String(defs.OptionCacheDir)
+	if dir == "" {
+		if base, err := os.UserCacheDir(); err == nil {
+			dir = filepath.Join(base, appDirName)
+		} else {
+			dir = filepath.Join(os.TempDir(), appDirName)
+		}
+	}
+
+	key := api
+	for _, h := range c.StringSlice(defs.OptionAPIHeader) {
+		key += "\n" + h
+	}
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// readCache reads a cached server list
+func readCache[T any](file string) (*cachedList[T], error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cached cachedList[T]
+	if err := json.Unmarshal(b, &cached); err != nil {
+		return nil, err
+	}
+	return &cached, nil
+}
+
+// writeCache writes a server list to the cache, replacing the previous one atomically
+func writeCache[T any](file string, cached cachedList[T]) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&cached)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
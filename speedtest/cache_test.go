@@ -0,0 +1,137 @@
+package speedtest
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// testContext returns a context with the given flags parsed from args
+func testContext(t *testing.T, flags []cli.Flag, args ...string) *cli.Context {
+	t.Helper()
+
+	set := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	for _, f := range flags {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+// cacheContext returns a context for cachedApiGet requesting the given API with the cache in dir
+func cacheContext(t *testing.T, api, dir string, args ...string) *cli.Context {
+	return testContext(t, []cli.Flag{
+		&cli.StringFlag{Name: defs.OptionAPIBase},
+		&cli.StringFlag{Name: defs.OptionAPIVersion},
+		&cli.StringSliceFlag{Name: defs.OptionAPIHeader},
+		&cli.IntFlag{Name: defs.OptionCacheTTL},
+		&cli.StringFlag{Name: defs.OptionCacheDir},
+		&cli.BoolFlag{Name: defs.OptionNoCache},
+	}, append([]string{"--" + defs.OptionAPIBase, api, "--" + defs.OptionAPIVersion, "v1", "--" + defs.OptionCacheDir, dir}, args...)...)
+}
+
+func TestCachedApiGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     string
+		noCache bool
+		// cached is the age of the cached list, none if 0
+		cached   time.Duration
+		apiDown  bool
+		wantID   string
+		wantHits int
+		wantErr  bool
+	}{
+		{name: "cached by default", ttl: "0", wantID: "remote", wantHits: 1},
+		{name: "not reused by default", ttl: "0", cached: time.Minute, wantID: "remote", wantHits: 1},
+		{name: "disabled by --no-cache", ttl: "3600", noCache: true, cached: time.Minute, wantID: "remote", wantHits: 1},
+		{name: "not cached with --no-cache", ttl: "0", noCache: true, wantID: "remote", wantHits: 1},
+		{name: "not cached yet", ttl: "3600", wantID: "remote", wantHits: 1},
+		{name: "fresh", ttl: "3600", cached: time.Minute, wantID: "cached", wantHits: 0},
+		{name: "expired", ttl: "60", cached: time.Hour, wantID: "remote", wantHits: 1},
+		{name: "stale fallback", ttl: "60", cached: time.Hour, apiDown: true, wantID: "cached", wantHits: 1},
+		{name: "no fallback", ttl: "60", apiDown: true, wantHits: 1, wantErr: true},
+		{name: "stale fallback by default", ttl: "0", cached: time.Hour, apiDown: true, wantID: "cached", wantHits: 1},
+		{name: "no fallback with --no-cache", ttl: "0", noCache: true, cached: time.Minute, apiDown: true, wantHits: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				if tt.apiDown {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.Write([]byte(`{"code": 0, "data": [{"id": "remote"}]}`))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			args := []string{"--" + defs.OptionCacheTTL, tt.ttl}
+			if tt.noCache {
+				args = append(args, "--"+defs.OptionNoCache)
+			}
+			c := cacheContext(t, srv.URL, dir, args...)
+
+			api := srv.URL + "/v1/node"
+			if tt.cached > 0 {
+				cached := cachedList[[]defs.Server]{API: api, FetchedAt: time.Now().Add(-tt.cached), Data: []defs.Server{{ID: "cached"}}}
+				if err := writeCache(cacheFile(c, api), cached); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			servers, err := cachedApiGet[[]defs.Server](c, "node", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cachedApiGet() error = %v, want error %t", err, tt.wantErr)
+			}
+			if hits != tt.wantHits {
+				t.Errorf("Core API requested %d times, want %d", hits, tt.wantHits)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(servers) != 1 || servers[0].ID != tt.wantID {
+				t.Errorf("cachedApiGet() = %+v, want the %s server", servers, tt.wantID)
+			}
+
+			// a list from the Core API is cached unless --no-cache is given
+			if _, err := os.Stat(cacheFile(c, api)); tt.cached == 0 && (err == nil) == tt.noCache {
+				t.Errorf("cache file exists = %t after fetching", err == nil)
+			}
+		})
+	}
+}
+
+func TestCacheFileHeaders(t *testing.T) {
+	dir := t.TempDir()
+	api := "https://example.com/api/v1/node"
+	file := func(args ...string) string {
+		return cacheFile(cacheContext(t, "https://example.com/api", dir, args...), api)
+	}
+
+	plain := file()
+	auth := file("--"+defs.OptionAPIHeader, "Authorization: Bearer a")
+	if plain == auth {
+		t.Errorf("cacheFile() is the same with and without --%s", defs.OptionAPIHeader)
+	}
+	if other := file("--"+defs.OptionAPIHeader, "Authorization: Bearer b"); other == auth {
+		t.Errorf("cacheFile() is the same for different --%s", defs.OptionAPIHeader)
+	}
+	if again := file("--"+defs.OptionAPIHeader, "Authorization: Bearer a"); again != auth {
+		t.Errorf("cacheFile() = %s, want %s for the same --%s", again, auth, defs.OptionAPIHeader)
+	}
+}
@@ -0,0 +1,194 @@
+package speedtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// thresholdOptions are the options enabling the threshold checks
+var thresholdOptions = []string{defs.OptionMinDownload, defs.OptionMinUpload, defs.OptionMaxPing, defs.OptionMaxJitter,
+	defs.OptionMaxLoss, defs.OptionMaxRegression}
+
+// checksEnabled checks whether any threshold is given
+func checksEnabled(c *cli.Context) bool {
+	for _, name := range thresholdOptions {
+		if c.IsSet(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runChecks checks the results of the selected servers against the thresholds given, the servers without a result, e.g.
+// the ones not responding, failing the `result` check. The regression checks compare the speeds with the mean of the
+// last --baseline-runs results of the same server and stack in the history, which must not include this run yet
+func runChecks(c *cli.Context, servers []defs.Server, results []defs.Result) []defs.Check {
+	var baselines map[string][2]float64
+	if c.IsSet(defs.OptionMaxRegression) {
+		baselines = historyBaselines(c, results)
+	}
+
+	var checks []defs.Check
+	for _, rep := range results {
+		add := func(name string, value, threshold float64, below bool, code int) *defs.Check {
+			passed := value <= threshold
+			if below {
+				passed = value >= threshold
+			}
+			checks = append(checks, defs.Check{Name: name, Server: rep.ID, Stack: rep.Stack, Value: value,
+				Threshold: threshold, Passed: passed, ExitCode: code})
+			return &checks[len(checks)-1]
+		}
+
+		// the speeds are not checked if the direction is skipped
+		download, upload := !c.Bool(defs.OptionNoDownload), !c.Bool(defs.OptionNoUpload)
+		if download && c.IsSet(defs.OptionMinDownload) {
+			add("download", rep.Download, c.Float64(defs.OptionMinDownload), true, defs.ExitDownload)
+		}
+		if upload && c.IsSet(defs.OptionMinUpload) {
+			add("upload", rep.Upload, c.Float64(defs.OptionMinUpload), true, defs.ExitUpload)
+		}
+		if c.IsSet(defs.OptionMaxPing) {
+			add("ping", rep.Ping, c.Float64(defs.OptionMaxPing), false, defs.ExitPing)
+		}
+		if c.IsSet(defs.OptionMaxJitter) {
+			add("jitter", rep.Jitter, c.Float64(defs.OptionMaxJitter), false, defs.ExitJitter)
+		}
+		if c.IsSet(defs.OptionMaxLoss) {
+			add("loss", rep.PacketLoss, c.Float64(defs.OptionMaxLoss), false, defs.ExitLoss)
+		}
+
+		if baseline, ok := baselines[rep.ID+"/"+rep.Stack]; ok {
+			ratio := 1 - c.Float64(defs.OptionMaxRegression)/100
+			if download && baseline[0] > 0 {
+				check := add("download-regression", rep.Download, math.Round(baseline[0]*ratio*100)/100, true, defs.ExitDownloadRegression)
+				check.Baseline = baseline[0]
+			}
+			if upload && baseline[1] > 0 {
+				check := add("upload-regression", rep.Upload, math.Round(baseline[1]*ratio*100)/100, true, defs.ExitUploadRegression)
+				check.Baseline = baseline[1]
+			}
+		}
+	}
+
+	// each server is expected to have a result per stack when testing dual-stack
+	dualStack := c.Bool(defs.OptionDual)
+	if dualStack {
+		servers = dualStackServers(servers)
+	}
+	tested := make(map[string]bool, len(results)*2)
+	for _, rep := range results {
+		tested[rep.ID] = true
+		tested[rep.ID+"/"+rep.Stack] = true
+	}
+	for _, server := range servers {
+		stack := defs.StackOf(server.Target).String()
+		key := server.ID
+		if dualStack {
+			key += "/" + stack
+		}
+		if !tested[key] {
+			checks = append(checks, defs.Check{Name: "result", Server: server.ID, Stack: stack, ExitCode: defs.ExitNoResult})
+		}
+	}
+
+	return checks
+}
+
+// historyBaselines returns the mean download and upload speeds of the last --baseline-runs results in the history of
+// each server and stack tested, keyed by `ID/stack`. Servers without history have no baseline, so they are not checked
+func historyBaselines(c *cli.Context, results []defs.Result) map[string][2]float64 {
+	var filter defs.HistoryFilter
+	for _, rep := range results {
+		filter.Servers = append(filter.Servers, rep.ID)
+	}
+
+	entries, err := defs.ReadHistory(historyFile(c), filter)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Error when reading history: %s", err)
+		}
+		return nil
+	}
+
+	runs := c.Int(defs.OptionBaselineRuns)
+	baselines := make(map[string][2]float64)
+	for _, rep := range results {
+		key := rep.ID + "/" + rep.Stack
+		if _, ok := baselines[key]; ok {
+			continue
+		}
+
+		var downloads, uploads int
+		var baseline [2]float64
+		for i := len(entries) - 1; i >= 0 && (downloads < runs || uploads < runs); i-- {
+			prev := &entries[i].Result
+			if prev.ID != rep.ID || prev.Stack != rep.Stack {
+				continue
+			}
+			if prev.Download > 0 && downloads < runs {
+				baseline[0] += prev.Download
+				downloads++
+			}
+			if prev.Upload > 0 && uploads < runs {
+				baseline[1] += prev.Upload
+				uploads++
+			}
+		}
+		if downloads == 0 && uploads == 0 {
+			log.Debugf("No history of server %s (%s) to compare with", rep.ID, rep.Stack)
+			continue
+		}
+
+		if downloads > 0 {
+			baseline[0] = math.Round(baseline[0]/float64(downloads)*100) / 100
+		}
+		if uploads > 0 {
+			baseline[1] = math.Round(baseline[1]/float64(uploads)*100) / 100
+		}
+		baselines[key] = baseline
+	}
+
+	return baselines
+}
+
+// reportChecks writes the summary of the checks as JSON to --check-summary, or to stderr if not given, and returns an
+// error with the exit code of the first failed check if any
+func reportChecks(c *cli.Context, checks []defs.Check) error {
+	summary := defs.NewCheckSummary(checks)
+
+	var w io.Writer = os.Stderr
+	switch path := c.String(defs.OptionCheckSummary); path {
+	case "":
+	case "-":
+		w = os.Stdout
+	default:
+		f, err := os.Create(path)
+		if err != nil {
+			log.Errorf("Error writing check summary: %s", err)
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	b, err := json.Marshal(&summary)
+	if err != nil {
+		return err
+	}
+	w.Write(append(b, '\n'))
+
+	if summary.Passed {
+		return nil
+	}
+	return cli.Exit(fmt.Sprintf("Checks failed: %s", strings.Join(summary.Failed, ", ")), summary.ExitCode)
+}
@@ -0,0 +1,99 @@
+package speedtest
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// checkContext returns a context for runChecks with the history in dir
+func checkContext(t *testing.T, dir string, args ...string) *cli.Context {
+	return testContext(t, []cli.Flag{
+		&cli.Float64Flag{Name: defs.OptionMinDownload},
+		&cli.Float64Flag{Name: defs.OptionMinUpload},
+		&cli.Float64Flag{Name: defs.OptionMaxPing},
+		&cli.Float64Flag{Name: defs.OptionMaxJitter},
+		&cli.Float64Flag{Name: defs.OptionMaxLoss},
+		&cli.Float64Flag{Name: defs.OptionMaxRegression},
+		&cli.IntFlag{Name: defs.OptionBaselineRuns, Value: 2},
+		&cli.BoolFlag{Name: defs.OptionNoDownload},
+		&cli.BoolFlag{Name: defs.OptionNoUpload},
+		&cli.BoolFlag{Name: defs.OptionDual},
+		&cli.StringFlag{Name: defs.OptionHistoryDir},
+	}, append([]string{"--" + defs.OptionHistoryDir, dir}, args...)...)
+}
+
+func TestRunChecks(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Now().Add(-time.Hour)
+	if err := defs.AppendHistory(filepath.Join(dir, defs.HistoryFileName), []defs.HistoryEntry{
+		{Result: defs.Result{ID: "1", Stack: "ipv4", Download: 50, Upload: 10, Timestamp: at}},
+		{Result: defs.Result{ID: "1", Stack: "ipv4", Download: 100, Upload: 20, Timestamp: at}},
+		{Result: defs.Result{ID: "1", Stack: "ipv4", Download: 200, Upload: 40, Timestamp: at}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	servers := []defs.Server{{ID: "1", IP: "192.0.2.1", IPv6: "2001:db8::1", Target: "192.0.2.1"}}
+	result := defs.Result{ID: "1", Stack: "ipv4", Download: 90, Upload: 30, Ping: 20, Jitter: 2, PacketLoss: 10}
+
+	// check represents a check as name, pass and exit code
+	type check struct {
+		name   string
+		passed bool
+		code   int
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		servers []defs.Server
+		results []defs.Result
+		want    []check
+	}{
+		{"thresholds", []string{"--min-download", "100", "--min-upload", "30", "--max-ping", "20", "--max-jitter", "1", "--max-loss", "5"},
+			servers, []defs.Result{result}, []check{
+				{"download", false, defs.ExitDownload}, {"upload", true, defs.ExitUpload}, {"ping", true, defs.ExitPing},
+				{"jitter", false, defs.ExitJitter}, {"loss", false, defs.ExitLoss},
+			}},
+		{"skipped directions", []string{"--min-download", "100", "--min-upload", "100", "--no-download", "--no-upload"},
+			servers, []defs.Result{result}, nil},
+		{"regression against the last runs", []string{"--max-regression", "40"},
+			servers, []defs.Result{result}, []check{
+				{"download-regression", true, defs.ExitDownloadRegression}, {"upload-regression", true, defs.ExitUploadRegression},
+			}},
+		{"regression beyond the threshold", []string{"--max-regression", "30"},
+			servers, []defs.Result{result}, []check{
+				{"download-regression", false, defs.ExitDownloadRegression}, {"upload-regression", true, defs.ExitUploadRegression},
+			}},
+		{"no history", []string{"--max-regression", "10"},
+			[]defs.Server{{ID: "2", Target: "192.0.2.2"}}, []defs.Result{{ID: "2", Stack: "ipv4", Download: 1}}, nil},
+		{"server without result", []string{"--max-ping", "100"},
+			append(servers, defs.Server{ID: "2", Target: "192.0.2.2"}), []defs.Result{result}, []check{
+				{"ping", true, defs.ExitPing}, {"result", false, defs.ExitNoResult},
+			}},
+		{"stack without result", []string{"--max-ping", "100", "--dual"},
+			servers, []defs.Result{result}, []check{
+				{"ping", true, defs.ExitPing}, {"result", false, defs.ExitNoResult},
+			}},
+		{"no result at all", []string{"--max-ping", "100"},
+			servers, nil, []check{{"result", false, defs.ExitNoResult}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []check
+			for _, c := range runChecks(checkContext(t, dir, tt.args...), tt.servers, tt.results) {
+				got = append(got, check{c.Name, c.Passed, c.ExitCode})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runChecks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
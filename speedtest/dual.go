@@ -0,0 +1,100 @@
+package speedtest
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// dualStackServers returns each server twice, targeting its IPv4 and then its IPv6 address
+func dualStackServers(servers []defs.Server) []defs.Server {
+	var ret []defs.Server
+	for _, server := range servers {
+		v4, v6 := server, server
+		v4.Target, v6.Target = server.IP, server.IPv6
+		ret = append(ret, v4, v6)
+	}
+	return ret
+}
+
+// printStackComparison prints the IPv4 and IPv6 results of each server side by side
+func printStackComparison(reps []defs.Result, useBytes, useMebi bool) {
+	type pair struct {
+		name   string
+		v4, v6 *defs.Result
+	}
+
+	var ids []string
+	pairs := make(map[string]*pair)
+	for i := range reps {
+		rep := &reps[i]
+		p, ok := pairs[rep.ID]
+		if !ok {
+			p = &pair{name: rep.Name}
+			pairs[rep.ID] = p
+			ids = append(ids, rep.ID)
+		}
+		if rep.Stack == defs.StackIPv6.String() {
+			p.v6 = rep
+		} else {
+			p.v4 = rep
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	latency := func(rep *defs.Result) string {
+		if rep == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f ms", rep.Ping)
+	}
+	jitter := func(rep *defs.Result) string {
+		if rep == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f ms", rep.Jitter)
+	}
+	loss := func(rep *defs.Result) string {
+		if rep == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%%", rep.PacketLoss)
+	}
+	speed := func(rep *defs.Result, upload bool) string {
+		if rep == nil {
+			return "-"
+		}
+		mbps := rep.Download
+		if upload {
+			mbps = rep.Upload
+		}
+		if mbps == 0 {
+			return "-"
+		}
+		if useBytes {
+			return humanizeMbps(mbps, useMebi)
+		}
+		return fmt.Sprintf("%.2f Mbps", mbps)
+	}
+
+	fmt.Println()
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"ID", "Name", "", "IPv4", "IPv6"})
+	for _, id := range ids {
+		p := pairs[id]
+		t.AppendRow(table.Row{id, p.name, "Latency", latency(p.v4), latency(p.v6)})
+		t.AppendRow(table.Row{"", "", "Jitter", jitter(p.v4), jitter(p.v6)})
+		t.AppendRow(table.Row{"", "", "Loss", loss(p.v4), loss(p.v6)})
+		t.AppendRow(table.Row{"", "", "Download", speed(p.v4, false), speed(p.v6, false)})
+		t.AppendRow(table.Row{"", "", "Upload", speed(p.v4, true), speed(p.v6, true)})
+	}
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.Render()
+}
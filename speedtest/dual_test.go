@@ -0,0 +1,45 @@
+package speedtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+func TestDualStackServers(t *testing.T) {
+	beijing := defs.Server{ID: "1", IP: "192.0.2.1", IPv6: "2001:db8::1", Target: "192.0.2.1"}
+	shanghai := defs.Server{ID: "2", IP: "192.0.2.2", IPv6: "2001:db8::2", Target: "2001:db8::2"}
+	target := func(s defs.Server, target string) defs.Server {
+		s.Target = target
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		servers []defs.Server
+		want    []defs.Server
+	}{
+		{"no server", nil, nil},
+		{"one server", []defs.Server{beijing}, []defs.Server{beijing, target(beijing, "2001:db8::1")}},
+		{
+			"servers in order",
+			[]defs.Server{shanghai, beijing},
+			[]defs.Server{target(shanghai, "192.0.2.2"), shanghai, beijing, target(beijing, "2001:db8::1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dualStackServers(tt.servers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dualStackServers() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if stack := defs.StackOf(got[i].Target); (i%2 == 0) != (stack == defs.StackIPv4) {
+					t.Errorf("server %d targets %s over %s", i, got[i].Target, stack)
+				}
+			}
+		})
+	}
+}
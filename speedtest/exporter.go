@@ -0,0 +1,201 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// exporterShutdownTimeout is how long the running scrapes are waited for when the exporter is stopped
+const exporterShutdownTimeout = 5 * time.Second
+
+// exporter runs the speed tests on a schedule or on scrape, and exposes the results of the last run as Prometheus
+// metrics
+type exporter struct {
+	run         func(context.Context) ([]defs.Result, error)
+	minInterval time.Duration
+
+	// running is held for the whole run, so the scrapes during a test are served the results of the last one instead of
+	// starting another or waiting for it, and lock guards the results
+	running  sync.Mutex
+	lock     sync.Mutex
+	results  []defs.Result
+	success  bool
+	lastRun  time.Time
+	finished time.Time
+	duration time.Duration
+	runs     uint64
+	failures uint64
+}
+
+// runExporter serves the metrics on --exporter until ctx is done. The servers are tested every --exporter-interval, or
+// when scraped if it is 0, but never more often than --exporter-min-interval. The default minimum interval only applies
+// to the tests on scrape
+func runExporter(ctx context.Context, c *cli.Context, run func(context.Context) ([]defs.Result, error)) error {
+	e := &exporter{
+		run:         run,
+		minInterval: time.Duration(c.Int(defs.OptionExporterMin)) * time.Second,
+	}
+	interval := time.Duration(c.Int(defs.OptionExporterEvery)) * time.Second
+	if interval > 0 && !c.IsSet(defs.OptionExporterMin) {
+		e.minInterval = 0
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if interval == 0 {
+			// the test is not bound to the scrape, so that its results are kept for the next one if this one times out
+			e.test(ctx)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(e.metrics())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "<html><body><h1>TaierSpeed exporter</h1><p><a href=\"/metrics\">Metrics</a></p></body></html>")
+	})
+
+	errorLog := log.StandardLogger().WriterLevel(log.DebugLevel)
+	defer errorLog.Close()
+	server := &http.Server{Addr: c.String(defs.OptionExporter), Handler: mux, ErrorLog: stdlog.New(errorLog, "", 0)}
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	if interval > 0 {
+		log.Infof("Serving metrics on %s, testing every %s", server.Addr, interval)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				e.test(ctx)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	} else {
+		log.Infof("Serving metrics on %s, testing on scrape at most every %s", server.Addr, e.minInterval)
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		log.Info("Shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), exporterShutdownTimeout)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("Error when serving metrics: %s", err)
+		return err
+	}
+	return nil
+}
+
+// test runs the speed tests unless the last run finished less than minInterval ago or another run is in progress
+func (e *exporter) test(ctx context.Context) {
+	if !e.running.TryLock() {
+		log.Debugf("Test in progress, serving the results of the last one")
+		return
+	}
+	defer e.running.Unlock()
+
+	e.lock.Lock()
+	finished := e.finished
+	e.lock.Unlock()
+
+	if !finished.IsZero() && time.Since(finished) < e.minInterval {
+		log.Debugf("Last test finished %s ago, serving its results", time.Since(finished).Round(time.Second))
+		return
+	}
+
+	log.Info("Running speed test")
+	start := time.Now()
+	results, err := e.run(ctx)
+	if ctx.Err() != nil {
+		// the exporter is shutting down, keep the previous results
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.lastRun, e.finished, e.duration = start, time.Now(), time.Since(start)
+	e.runs++
+	e.success = err == nil && len(results) > 0
+	if !e.success {
+		e.failures++
+		log.Warnf("Speed test failed: %v", err)
+	}
+	if len(results) > 0 {
+		e.results = results
+	}
+}
+
+// metrics returns the metrics of the last run in the Prometheus text exposition format
+func (e *exporter) metrics() []byte {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var buf bytes.Buffer
+	gauge := func(name, help string, value func(*defs.Result) float64) {
+		if len(e.results) == 0 {
+			return
+		}
+		fmt.Fprintf(&buf, "# HELP taierspeed_%s %s\n# TYPE taierspeed_%s gauge\n", name, help, name)
+		for i := range e.results {
+			rep := &e.results[i]
+			fmt.Fprintf(&buf, "taierspeed_%s{id=\"%s\",name=\"%s\",isp=\"%s\",province=\"%s\",stack=\"%s\"} %g\n", name,
+				escapeLabel(rep.ID), escapeLabel(rep.Name), escapeLabel(rep.ISP), escapeLabel(rep.Province), escapeLabel(rep.Stack), value(rep))
+		}
+	}
+
+	gauge("download_mbps", "Download speed of the last test in Mbps.", func(r *defs.Result) float64 { return r.Download })
+	gauge("upload_mbps", "Upload speed of the last test in Mbps.", func(r *defs.Result) float64 { return r.Upload })
+	gauge("ping_ms", "Average latency of the last test in milliseconds.", func(r *defs.Result) float64 { return r.Ping })
+	gauge("jitter_ms", "Jitter of the last test in milliseconds.", func(r *defs.Result) float64 { return r.Jitter })
+	gauge("packet_loss_percent", "Packet loss of the pings of the last test in percent.", func(r *defs.Result) float64 { return r.PacketLoss })
+	gauge("download_bytes", "Bytes received by the download test of the last test.", func(r *defs.Result) float64 { return float64(r.BytesReceived) })
+	gauge("upload_bytes", "Bytes sent by the upload test of the last test.", func(r *defs.Result) float64 { return float64(r.BytesSent) })
+	gauge("timestamp_seconds", "Unix time the last test of the server finished at.", func(r *defs.Result) float64 { return float64(r.Timestamp.Unix()) })
+
+	success := 0
+	if e.success {
+		success = 1
+	}
+	var lastRun int64
+	if !e.lastRun.IsZero() {
+		lastRun = e.lastRun.Unix()
+	}
+	fmt.Fprintf(&buf, "# HELP taierspeed_up Whether the last run succeeded.\n# TYPE taierspeed_up gauge\ntaierspeed_up %d\n", success)
+	fmt.Fprintf(&buf, "# HELP taierspeed_last_run_timestamp_seconds Unix time the last run started at.\n# TYPE taierspeed_last_run_timestamp_seconds gauge\ntaierspeed_last_run_timestamp_seconds %d\n", lastRun)
+	fmt.Fprintf(&buf, "# HELP taierspeed_last_run_duration_seconds Duration of the last run.\n# TYPE taierspeed_last_run_duration_seconds gauge\ntaierspeed_last_run_duration_seconds %g\n", e.duration.Seconds())
+	fmt.Fprintf(&buf, "# HELP taierspeed_runs_total Number of runs.\n# TYPE taierspeed_runs_total counter\ntaierspeed_runs_total %d\n", e.runs)
+	fmt.Fprintf(&buf, "# HELP taierspeed_run_failures_total Number of failed runs.\n# TYPE taierspeed_run_failures_total counter\ntaierspeed_run_failures_total %d\n", e.failures)
+
+	return buf.Bytes()
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(val)
+}
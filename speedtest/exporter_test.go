@@ -0,0 +1,150 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// stubRun is the outcome of a run of the stub tests
+type stubRun struct {
+	results []defs.Result
+	err     error
+}
+
+// stubExporter returns an exporter whose runs return the given outcomes in order, and the number of runs made
+func stubExporter(minInterval time.Duration, runs ...stubRun) (*exporter, *int) {
+	calls := 0
+	e := &exporter{
+		minInterval: minInterval,
+		run: func(ctx context.Context) ([]defs.Result, error) {
+			run := runs[min(calls, len(runs)-1)]
+			calls++
+			return run.results, run.err
+		},
+	}
+	return e, &calls
+}
+
+// checkMetrics checks that the metrics have every line of want
+func checkMetrics(t *testing.T, e *exporter, want ...string) {
+	t.Helper()
+	metrics := string(e.metrics())
+	for _, line := range want {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("metrics do not have %q:\n%s", line, metrics)
+		}
+	}
+}
+
+const downloadGauge = `taierspeed_download_mbps{id="1",name="test",isp="",province="",stack=""}`
+
+func TestExporterTest(t *testing.T) {
+	ok := stubRun{results: []defs.Result{{ID: "1", Name: "test", Download: 100}}}
+	faster := stubRun{results: []defs.Result{{ID: "1", Name: "test", Download: 200}}}
+	failed := stubRun{err: errors.New("no server")}
+
+	tests := []struct {
+		name        string
+		minInterval time.Duration
+		runs        []stubRun
+		tests       int
+		wantCalls   int
+		want        []string
+	}{
+		{
+			name: "no run",
+			want: []string{"taierspeed_up 0", "taierspeed_runs_total 0", "taierspeed_last_run_timestamp_seconds 0"},
+		},
+		{
+			name:      "successful run",
+			runs:      []stubRun{ok},
+			tests:     1,
+			wantCalls: 1,
+			want:      []string{downloadGauge + " 100", "taierspeed_up 1", "taierspeed_runs_total 1"},
+		},
+		{
+			name:      "runs without minimum interval",
+			runs:      []stubRun{ok, faster},
+			tests:     2,
+			wantCalls: 2,
+			want:      []string{downloadGauge + " 200", "taierspeed_up 1", "taierspeed_runs_total 2"},
+		},
+		{
+			name:        "runs within the minimum interval",
+			minInterval: time.Hour,
+			runs:        []stubRun{ok, faster},
+			tests:       3,
+			wantCalls:   1,
+			want:        []string{downloadGauge + " 100", "taierspeed_up 1", "taierspeed_runs_total 1"},
+		},
+		{
+			name:      "failed run keeps the stale gauges",
+			runs:      []stubRun{ok, failed},
+			tests:     2,
+			wantCalls: 2,
+			want: []string{downloadGauge + " 100", "taierspeed_up 0", "taierspeed_runs_total 2",
+				"taierspeed_run_failures_total 1"},
+		},
+		{
+			name:      "run without results",
+			runs:      []stubRun{{}},
+			tests:     1,
+			wantCalls: 1,
+			want:      []string{"taierspeed_up 0", "taierspeed_run_failures_total 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, calls := stubExporter(tt.minInterval, tt.runs...)
+			for i := 0; i < tt.tests; i++ {
+				e.test(context.Background())
+			}
+
+			if *calls != tt.wantCalls {
+				t.Errorf("got %d runs, want %d", *calls, tt.wantCalls)
+			}
+			checkMetrics(t, e, tt.want...)
+		})
+	}
+}
+
+func TestExporterScrapeDuringRun(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	calls := 0
+	e := &exporter{
+		run: func(ctx context.Context) ([]defs.Result, error) {
+			calls++
+			if calls == 1 {
+				return []defs.Result{{ID: "1", Name: "test", Download: 100}}, nil
+			}
+			close(started)
+			<-release
+			return []defs.Result{{ID: "1", Name: "test", Download: 200}}, nil
+		},
+	}
+	e.test(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		e.test(context.Background())
+		close(done)
+	}()
+	<-started
+
+	// the scrape neither waits for the run nor starts another one
+	e.test(context.Background())
+	checkMetrics(t, e, downloadGauge+" 100", "taierspeed_runs_total 1")
+
+	close(release)
+	<-done
+	if calls != 2 {
+		t.Errorf("got %d runs, want 2", calls)
+	}
+	checkMetrics(t, e, downloadGauge+" 200", "taierspeed_runs_total 2")
+}
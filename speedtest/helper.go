@@ -6,6 +6,7 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -194,7 +195,7 @@ func getServerMatch(c *cli.Context, ispInfo *defs.IPInfoResponse, stack defs.Sta
 		v.Add("stack", strconv.Itoa(int(stack)))
 	}
 
-	return apiGet[[]defs.Server](c, "node/match", v)
+	return cachedApiGet[[]defs.Server](c, "node/match", v)
 }
 
 func getServerList(c *cli.Context, servers *[]string, groups *[]string, stack defs.Stack) ([]defs.ServerResponse, error) {
@@ -209,7 +210,7 @@ func getServerList(c *cli.Context, servers *[]string, groups *[]string, stack de
 		v.Add("stack", strconv.Itoa(int(stack)))
 	}
 
-	return apiGet[[]defs.ServerResponse](c, "node", v)
+	return cachedApiGet[[]defs.ServerResponse](c, "node", v)
 }
 
 func getVersion(c *cli.Context) (defs.Version, error) {
@@ -316,8 +317,12 @@ func MatchISP(isp string) uint8 {
 	return 0
 }
 
-// doSpeedTest is where the actual speed test happens
-func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent bool, pingType defs.PingType, ispInfo *defs.IPInfoResponse) error {
+// doSpeedTest is where the actual speed test happens, and returns the results which are kept for the output. When ctx is
+// done, the remaining tests are skipped and the results gathered so far are reported as partial. The progress of the
+// tests is streamed to events if not nil
+func doSpeedTest(ctx context.Context, c *cli.Context, servers []defs.Server, network string, silent bool, pingType defs.PingType, ispInfo *defs.IPInfoResponse, client *http.Client, events *defs.EventWriter) ([]defs.Result, error) {
+	jitterAlg, _ := defs.ParseJitterAlgorithm(c.String(defs.OptionJitter))
+
 	if !silent || c.Bool(defs.OptionSimple) {
 		if serverCount := len(servers); serverCount > 1 {
 			fmt.Printf("Testing against %d servers: [ %s ]\n", serverCount, strings.Join(func() []string {
@@ -329,7 +334,7 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent b
 			}(), ", "))
 		} else if serverCount == 0 {
 			fmt.Println("No server available")
-			return nil
+			return nil, nil
 		}
 		if ispInfo != nil {
 			fmt.Println()
@@ -348,10 +353,27 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent b
 		}
 	}
 
+	// test each server over IPv4 and then over IPv6 if --dual is given
+	dualStack := c.Bool(defs.OptionDual)
+	if dualStack {
+		servers = dualStackServers(servers)
+	}
+
+	// the results are kept for --csv, --json, --format, the comparison of --dual, the exporter, the history and the
+	// threshold checks
+	keepResults := c.Bool(defs.OptionCSV) || c.Bool(defs.OptionJSON) || c.String(defs.OptionFormat) != "" || dualStack ||
+		c.String(defs.OptionExporter) != "" || !c.Bool(defs.OptionNoHistory) || checksEnabled(c)
+
 	var repsOut []defs.Result
+	var interrupted bool
 
 	// fetch current user's IP info
 	for _, currentServer := range servers {
+		if ctx.Err() != nil {
+			interrupted = true
+			break
+		}
+
 		if !silent || c.Bool(defs.OptionSimple) {
 			name := currentServer.Name
 			if currentServer.Type == defs.Perception {
@@ -373,6 +395,59 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent b
 			fmt.Printf("Server:\t\t%s [%s] (id = %s)\n", name, currentServer.Target, currentServer.ID)
 		}
 
+		currentServer.Events = events
+		events.Emit(defs.EventServerSelected, defs.ServerEvent{
+			ID:       currentServer.ID,
+			Name:     currentServer.Name,
+			Target:   currentServer.Target,
+			Province: currentServer.Province,
+			City:     currentServer.City,
+			ISP:      defs.ISPMap[currentServer.ISP].Name,
+			Stack:    defs.StackOf(currentServer.Target).String(),
+			Type:     currentServer.Type.String(),
+		})
+
+		// ping over the IP version of the server's address when testing dual-stack
+		serverNetwork := network
+		if dualStack {
+			serverNetwork = "ip4"
+			if defs.StackOf(currentServer.Target) == defs.StackIPv6 {
+				serverNetwork = "ip6"
+			}
+		}
+
+		// only analyse the path if --trace-only is given
+		if c.Bool(defs.OptionTraceOnly) {
+			hops := runTrace(ctx, c, currentServer, silent)
+			if keepResults {
+				var rep defs.Result
+				rep.Timestamp = time.Now()
+				rep.Trace = hops
+
+				rep.ID = currentServer.ID
+				rep.IP = currentServer.Target
+				rep.Name = currentServer.Name
+				rep.Province = currentServer.Province
+				rep.City = currentServer.City
+				rep.ISP = defs.ISPMap[currentServer.ISP].Name
+				rep.Stack = defs.StackOf(currentServer.Target).String()
+				rep.Type = currentServer.Type.String()
+
+				repsOut = append(repsOut, rep)
+				events.Emit(defs.EventResult, rep)
+			}
+
+			if len(servers) > 1 && (!silent || c.Bool(defs.OptionSimple)) {
+				log.Warn()
+			}
+			continue
+		}
+
+		// record the connection phases of the HTTP requests for debug output and --timings
+		if c.Bool(defs.OptionTimings) || log.GetLevel() == log.DebugLevel {
+			currentServer.Timings = defs.NewTimings()
+		}
+
 		if currentServer.IsUp() {
 			// get ping and jitter value
 			var pb *spinner.Spinner
@@ -384,92 +459,221 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent b
 
 			// skip ICMP if option given
 			currentServer.PingType = pingType
+			currentServer.JitterAlgorithm = jitterAlg
 
-			p, jitter, err := currentServer.ICMPPingAndJitter(c.Int(defs.OptionPingCount), c.String(defs.OptionSource), network)
+			latency, err := currentServer.ICMPPingAndJitter(ctx, c.Int(defs.OptionPingCount), c.String(defs.OptionSource), serverNetwork)
 			if err != nil {
+				if pb != nil {
+					pb.Stop()
+				}
+				if ctx.Err() != nil {
+					interrupted = true
+					break
+				}
 				log.Errorf("Failed to get ping and jitter: %s", err)
-				return err
+				return nil, err
 			}
 
+			latencyMsg := fmt.Sprintf("Latency:\t%.2f ms (%.2f ms jitter)\n", latency.Avg, latency.Jitter)
 			if pb != nil {
-				pb.FinalMSG = fmt.Sprintf("Latency:\t%.2f ms (%.2f ms jitter)\n", p, jitter)
+				pb.FinalMSG = latencyMsg + fmt.Sprintf("\t\t(min: %.2f ms, max: %.2f ms, median: %.2f ms, stddev: %.2f ms, loss: %.2f%%)\n",
+					latency.Min, latency.Max, latency.Median, latency.StdDev, latency.Loss)
 				pb.Stop()
 			} else if c.Bool(defs.OptionSimple) {
-				fmt.Printf("Latency:\t%.2f ms (%.2f ms jitter)\n", p, jitter)
+				fmt.Print(latencyMsg)
+			}
+			if latency.Loss > 0 {
+				log.Warnf("%d of %d pings to server %s (%s) were lost", latency.Sent-latency.Received, latency.Sent, currentServer.Name, currentServer.ID)
 			}
 
 			token := ""
 			if currentServer.Type == defs.GlobalSpeed && !(c.Bool(defs.OptionNoDownload) && c.Bool(defs.OptionNoUpload)) {
 				token = enQueue(currentServer)
 				if len(token) <= 0 || token == "-" {
-					log.Errorf("Get token failed")
-					return nil
+					// the server is left without a result, which fails its checks, and the next one is tested
+					log.Errorf("Failed to queue for server %s (%s): get token failed", currentServer.Name, currentServer.ID)
+					events.Emit(defs.EventError, defs.ErrorEvent{Message: fmt.Sprintf("Failed to queue for server %s (%s)", currentServer.Name, currentServer.ID)})
+					if len(servers) > 1 && (!silent || c.Bool(defs.OptionSimple)) {
+						log.Warn()
+					}
+					continue
 				}
 			}
 
+			warmup, autoWarmup, _ := parseWarmup(c.String(defs.OptionWarmup))
+			volume := uint64(c.Int(defs.OptionVolume)) * 1000000
+			if c.Bool(defs.OptionMebiBytes) {
+				volume = uint64(c.Int(defs.OptionVolume)) * 1048576
+			}
+			// 0 requests stands for tuning the number of connections automatically
+			requests, _ := strconv.Atoi(c.String(defs.OptionConcurrent))
+			metric := defs.MetricMean
+			if c.String(defs.OptionSpeedMetric) == "p90" {
+				metric = defs.MetricP90
+			}
+			opts := defs.TransferOptions{
+				Silent:     silent,
+				UseBytes:   c.Bool(defs.OptionBytes),
+				UseMebi:    c.Bool(defs.OptionMebiBytes),
+				NoPrealloc: c.Bool(defs.OptionNoPreAllocate),
+				Requests:   requests,
+				UploadSize: c.Int(defs.OptionUploadSize),
+				Duration:   time.Duration(c.Int(defs.OptionDuration)) * time.Second,
+				Volume:     volume,
+				Interval:   time.Duration(c.Int(defs.OptionInterval)) * time.Millisecond,
+				Warmup:     warmup,
+				AutoWarmup: autoWarmup,
+				Metric:     metric,
+				Token:      token,
+				Client:     client,
+
+				ProbeLatency: !c.Bool(defs.OptionNoBufferbloat),
+				Source:       c.String(defs.OptionSource),
+				Network:      serverNetwork,
+			}
+			if volume > 0 && !c.Bool(defs.OptionStopFirst) {
+				opts.Duration = maxVolumeDuration
+			}
+
 			// get download value
-			var downloadValue float64
-			var bytesRead uint64
+			var download defs.TransferResult
 			if c.Bool(defs.OptionNoDownload) {
 				log.Info("Download test is disabled")
 			} else {
-				download, br, err := currentServer.Download(silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), time.Duration(c.Int(defs.OptionDuration))*time.Second, token)
+				res, err := currentServer.Download(ctx, opts)
 				if err != nil {
 					log.Errorf("Failed to get download speed: %s", err)
-					return err
+					if token != "" {
+						deQueue(currentServer, token)
+					}
+					return nil, err
 				}
 				if c.Bool(defs.OptionSimple) {
-					if c.Bool(defs.OptionBytes) {
-						useMebi := c.Bool(defs.OptionMebiBytes)
-						fmt.Printf("Download:\t%s (data used: %s)\n", humanizeMbps(download, useMebi), humanizeBytes(br, useMebi))
+					useBytes, useMebi := c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes)
+					if useBytes {
+						fmt.Printf("Download:\t%s (data used: %s)\n", humanizeMbps(res.Mbps, useMebi), dataUsed(res, opts.Volume, useBytes, useMebi))
 					} else {
-						fmt.Printf("Download:\t%.2f Mbps (data used: %.2f MB)\n", download, float64(br)/1000000)
+						fmt.Printf("Download:\t%.2f Mbps (data used: %s)\n", res.Mbps, dataUsed(res, opts.Volume, useBytes, useMebi))
 					}
 				}
-				downloadValue = download
-				bytesRead = br
+				if res.Unbalanced {
+					log.Warnf("Download throughput came from a subset of connections, the others kept failing")
+				}
+				download = *res
 			}
 
 			// get upload value
-			var uploadValue float64
-			var bytesWritten uint64
+			var upload defs.TransferResult
 			if c.Bool(defs.OptionNoUpload) {
 				log.Info("Upload test is disabled")
 			} else if currentServer.Type == defs.StaticFile {
 				log.Info("Upload test is not supported for this server")
-			} else {
-				upload, bw, err := currentServer.Upload(c.Bool(defs.OptionNoPreAllocate), silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), c.Int(defs.OptionUploadSize), time.Duration(c.Int(defs.OptionDuration))*time.Second, token)
+			} else if ctx.Err() == nil {
+				res, err := currentServer.Upload(ctx, opts)
 				if err != nil {
 					log.Errorf("Failed to get upload speed: %s", err)
-					return err
+					if token != "" {
+						deQueue(currentServer, token)
+					}
+					return nil, err
 				}
 				if c.Bool(defs.OptionSimple) {
-					if c.Bool(defs.OptionBytes) {
-						useMebi := c.Bool(defs.OptionMebiBytes)
-						fmt.Printf("Upload:\t\t%s (data used: %s)\n", humanizeMbps(upload, useMebi), humanizeBytes(bw, useMebi))
+					useBytes, useMebi := c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes)
+					if useBytes {
+						fmt.Printf("Upload:\t\t%s (data used: %s)\n", humanizeMbps(res.Mbps, useMebi), dataUsed(res, opts.Volume, useBytes, useMebi))
 					} else {
-						fmt.Printf("Upload:\t\t%.2f Mbps (data used: %.2f MB)\n", upload, float64(bw)/1000000)
+						fmt.Printf("Upload:\t\t%.2f Mbps (data used: %s)\n", res.Mbps, dataUsed(res, opts.Volume, useBytes, useMebi))
 					}
 				}
-				uploadValue = upload
-				bytesWritten = bw
+				if res.Unbalanced {
+					log.Warnf("Upload throughput came from a subset of connections, the others kept failing")
+				}
+				upload = *res
 			}
 
 			if currentServer.Type == defs.GlobalSpeed && !(c.Bool(defs.OptionNoDownload) && c.Bool(defs.OptionNoUpload)) {
 				deQueue(currentServer, token)
 			}
 
+			bufferbloat := defs.BufferbloatGrade(latency.Avg, download.Latency, upload.Latency)
+			if bufferbloat != "" && (!silent || c.Bool(defs.OptionSimple)) {
+				var loaded []string
+				if download.Latency > 0 {
+					loaded = append(loaded, fmt.Sprintf("download %.2f ms (%.2f ms jitter)", download.Latency, download.Jitter))
+				}
+				if upload.Latency > 0 {
+					loaded = append(loaded, fmt.Sprintf("upload %.2f ms (%.2f ms jitter)", upload.Latency, upload.Jitter))
+				}
+				fmt.Printf("Loaded latency:\t%s\n", strings.Join(loaded, ", "))
+				fmt.Printf("Bufferbloat:\t%s\n", bufferbloat)
+			}
+
+			// analyse the path once the test is done, so that the probes do not affect it
+			var hops []defs.Hop
+			if c.Bool(defs.OptionTrace) && ctx.Err() == nil {
+				hops = runTrace(ctx, c, currentServer, silent)
+			}
+
 			// check for --csv or --json. the program prioritize the --csv before the --json. this is the same behavior as speedtest-cli
-			if c.Bool(defs.OptionCSV) || c.Bool(defs.OptionJSON) {
+			if keepResults {
 				var rep defs.Result
 				rep.Timestamp = time.Now()
 
-				rep.Ping = math.Round(p*100) / 100
-				rep.Jitter = math.Round(jitter*100) / 100
-				rep.Download = math.Round(downloadValue*100) / 100
-				rep.Upload = math.Round(uploadValue*100) / 100
-				rep.BytesReceived = bytesRead
-				rep.BytesSent = bytesWritten
+				rep.Ping = math.Round(latency.Avg*1000) / 1000
+				rep.Jitter = math.Round(latency.Jitter*1000) / 1000
+				rep.PingMin = math.Round(latency.Min*1000) / 1000
+				rep.PingMax = math.Round(latency.Max*1000) / 1000
+				rep.PingMedian = math.Round(latency.Median*1000) / 1000
+				rep.PingStdDev = math.Round(latency.StdDev*1000) / 1000
+				rep.PacketsSent = latency.Sent
+				rep.PacketsReceived = latency.Received
+				rep.JitterAlgorithm = c.String(defs.OptionJitter)
+				// the ping type actually used, after falling back from ICMP/UDP or TCP
+				rep.PingType = currentServer.PingType.String()
+				rep.PacketLoss = math.Round(latency.Loss*100) / 100
+				rep.PingSamples = latency.Rtts
+				rep.Download = math.Round(download.Mbps*100) / 100
+				rep.Upload = math.Round(upload.Mbps*100) / 100
+				rep.DownloadRaw = math.Round(download.RawMbps*100) / 100
+				rep.UploadRaw = math.Round(upload.RawMbps*100) / 100
+				rep.DownloadWarmup = math.Round(download.Warmup.Seconds()*1000) / 1000
+				rep.UploadWarmup = math.Round(upload.Warmup.Seconds()*1000) / 1000
+				rep.SpeedMetric = c.String(defs.OptionSpeedMetric)
+				rep.DownloadConcurrency = download.Concurrency
+				rep.UploadConcurrency = upload.Concurrency
+				rep.DownloadMean = math.Round(download.Stats.Mean*100) / 100
+				rep.DownloadPeak = math.Round(download.Stats.Peak*100) / 100
+				rep.DownloadP10 = math.Round(download.Stats.P10*100) / 100
+				rep.DownloadP50 = math.Round(download.Stats.P50*100) / 100
+				rep.DownloadP90 = math.Round(download.Stats.P90*100) / 100
+				rep.DownloadStdDev = math.Round(download.Stats.StdDev*100) / 100
+				rep.UploadMean = math.Round(upload.Stats.Mean*100) / 100
+				rep.UploadPeak = math.Round(upload.Stats.Peak*100) / 100
+				rep.UploadP10 = math.Round(upload.Stats.P10*100) / 100
+				rep.UploadP50 = math.Round(upload.Stats.P50*100) / 100
+				rep.UploadP90 = math.Round(upload.Stats.P90*100) / 100
+				rep.UploadStdDev = math.Round(upload.Stats.StdDev*100) / 100
+				rep.DownloadElapsed = math.Round(download.Elapsed.Seconds()*1000) / 1000
+				rep.UploadElapsed = math.Round(upload.Elapsed.Seconds()*1000) / 1000
+				rep.DownloadLatency = math.Round(download.Latency*1000) / 1000
+				rep.DownloadLatencyJitter = math.Round(download.Jitter*1000) / 1000
+				rep.UploadLatency = math.Round(upload.Latency*1000) / 1000
+				rep.UploadLatencyJitter = math.Round(upload.Jitter*1000) / 1000
+				rep.Bufferbloat = bufferbloat
+				rep.DownloadUnbalanced = download.Unbalanced
+				rep.UploadUnbalanced = upload.Unbalanced
+				rep.DownloadProtocol = download.Protocol
+				rep.UploadProtocol = upload.Protocol
+				rep.DownloadConnections = download.Conns
+				rep.UploadConnections = upload.Conns
+				rep.BytesReceived = download.Bytes
+				rep.BytesSent = upload.Bytes
+				rep.DownloadSamples = download.Samples
+				rep.UploadSamples = upload.Samples
+				if c.Bool(defs.OptionTimings) {
+					rep.Timings = currentServer.Timings.Summary()
+				}
+				rep.Trace = hops
 
 				rep.ID = currentServer.ID
 				rep.IP = currentServer.Target
@@ -477,11 +681,20 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent b
 				rep.Province = currentServer.Province
 				rep.City = currentServer.City
 				rep.ISP = defs.ISPMap[currentServer.ISP].Name
+				rep.Stack = defs.StackOf(currentServer.Target).String()
+				rep.Type = currentServer.Type.String()
 
 				repsOut = append(repsOut, rep)
+				events.Emit(defs.EventResult, rep)
+			}
+
+			if ctx.Err() != nil {
+				interrupted = true
+				break
 			}
 		} else {
 			log.Infof("Selected server %s (%s) is not responding at the moment, try again later", currentServer.Name, currentServer.ID)
+			events.Emit(defs.EventError, defs.ErrorEvent{Message: fmt.Sprintf("Server %s (%s) is not responding", currentServer.Name, currentServer.ID)})
 		}
 
 		//add a new line after each test if testing multiple servers
@@ -490,16 +703,21 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent b
 		}
 	}
 
+	if dualStack && !c.Bool(defs.OptionTraceOnly) && (!silent || c.Bool(defs.OptionSimple)) {
+		printStackComparison(repsOut, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes))
+	}
+
 	// check for --csv or --json. the program prioritize the --csv before the --json. this is the same behavior as speedtest-cli
 	if c.Bool(defs.OptionCSV) {
 		var buf bytes.Buffer
-		if err := gocsv.MarshalWithoutHeaders(&repsOut, &buf); err != nil {
+		rows := csvRows(repsOut, runParams(c, pingType))
+		if err := gocsv.MarshalWithoutHeaders(&rows, &buf); err != nil {
 			log.Errorf("Error generating CSV report: %s", err)
 		} else {
 			os.Stdout.WriteString(buf.String())
 		}
 	} else if c.Bool(defs.OptionJSON) {
-		jr := defs.JSONReport{Results: repsOut}
+		jr := defs.JSONReport{SchemaVersion: defs.ReportSchemaVersion, Run: runParams(c, pingType), Results: repsOut, Interrupted: interrupted}
 		if ispInfo != nil {
 			jr.Client = *ispInfo
 		}
@@ -508,9 +726,98 @@ func doSpeedTest(c *cli.Context, servers []defs.Server, network string, silent b
 		} else {
 			os.Stdout.Write(b[:])
 		}
+	} else if format := c.String(defs.OptionFormat); format != "" && format != "jsonl" {
+		if err := writeFormatted(format, c.String(defs.OptionOutput), c.StringSlice(defs.OptionOutputHeader), repsOut); err != nil {
+			log.Errorf("Error writing %s output: %s", format, err)
+			return repsOut, err
+		}
 	}
 
-	return nil
+	events.Emit(defs.EventDone, defs.DoneEvent{Results: len(repsOut), Interrupted: interrupted})
+
+	if interrupted {
+		return repsOut, cli.Exit("Test interrupted, results are partial", defs.ExitInterrupted)
+	}
+
+	return repsOut, nil
+}
+
+// csvRows returns copies of the results with the schema version and the run parameters filled, which are the trailing
+// CSV columns
+func csvRows(results []defs.Result, params defs.RunParams) []defs.Result {
+	rows := make([]defs.Result, len(results))
+	for i, rep := range results {
+		rep.SchemaVersion = defs.ReportSchemaVersion
+		rep.Run = params
+		rows[i] = rep
+	}
+	return rows
+}
+
+// runParams returns the parameters of the run for the report and the history, with the ping type resolved at startup,
+// which may differ from the requested one
+func runParams(c *cli.Context, pingType defs.PingType) defs.RunParams {
+	stack := defs.StackAll
+	switch {
+	case c.Bool(defs.OptionDual):
+		stack = defs.StackDual
+	case c.Bool(defs.OptionIPv4):
+		stack = defs.StackIPv4
+	case c.Bool(defs.OptionIPv6):
+		stack = defs.StackIPv6
+	}
+
+	return defs.RunParams{
+		Version:     defs.ProgVersion,
+		Commit:      defs.ProgCommit,
+		Stack:       stack.String(),
+		Source:      c.String(defs.OptionSource),
+		Interface:   c.String(defs.OptionInterface),
+		PingType:    pingType.String(),
+		Concurrent:  c.String(defs.OptionConcurrent),
+		Duration:    c.Int(defs.OptionDuration),
+		Volume:      c.Int(defs.OptionVolume),
+		UploadSize:  c.Int(defs.OptionUploadSize),
+		Protocol:    c.String(defs.OptionProtocol),
+		Warmup:      c.String(defs.OptionWarmup),
+		SpeedMetric: c.String(defs.OptionSpeedMetric),
+		NoDownload:  c.Bool(defs.OptionNoDownload),
+		NoUpload:    c.Bool(defs.OptionNoUpload),
+	}
+}
+
+// parseWarmup parses the warm-up option, which is either a period in seconds or `auto`
+func parseWarmup(val string) (time.Duration, bool, error) {
+	if val == "auto" {
+		return 0, true, nil
+	}
+
+	sec, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, false, err
+	} else if sec < 0 {
+		return 0, false, errors.New("negative warm-up period")
+	}
+
+	return time.Duration(sec * float64(time.Second)), false, nil
+}
+
+// dataUsed returns the data used in MB or in a human-readable form, together with the volume limit if set
+func dataUsed(res *defs.TransferResult, limit uint64, useBytes, useMebi bool) string {
+	var base float64 = 1000000
+	if useMebi {
+		base = 1048576
+	}
+
+	used, total := fmt.Sprintf("%.2f MB", float64(res.Bytes)/base), fmt.Sprintf("%.2f MB", float64(limit)/base)
+	if useBytes {
+		used, total = humanizeBytes(res.Bytes, useMebi), humanizeBytes(limit, useMebi)
+	}
+
+	if limit > 0 {
+		return fmt.Sprintf("%s of %s limit in %.2f s", used, total, res.Elapsed.Seconds())
+	}
+	return used
 }
 
 func humanizeMbps(mbps float64, useMebi bool) string {
@@ -535,11 +842,11 @@ func humanizeMbps(mbps float64, useMebi bool) string {
 
 // humanizeBytes returns the Bytes/KiloBytes/MegaBytes/GigaBytes (or Bytes/KibiBytes/MebiBytes/GibiBytes)
 func humanizeBytes(bytes uint64, useMebi bool) string {
-	val := float64(bytes) / 8
 	var base float64 = 1000
 	if useMebi {
 		base = 1024
 	}
+	val := float64(bytes) / base / base
 
 	if val < 1 {
 		if kb := val * base; kb < 1 {
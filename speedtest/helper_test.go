@@ -0,0 +1,214 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+func TestRunParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		pingType  defs.PingType
+		wantStack string
+		wantPing  string
+	}{
+		{"requested ping type", []string{"--ping", "tcp"}, defs.TCP, "all", "tcp"},
+		{"ping type downgraded", []string{"--ping", "icmp"}, defs.UDP, "all", "udp"},
+		{"ping type downgraded over dual stack", []string{"--ping", "udp", "--dual"}, defs.TCP, "dual", "tcp"},
+		{"IPv6", []string{"--ipv6"}, defs.ICMP, "ipv6", "icmp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testContext(t, []cli.Flag{
+				&cli.StringFlag{Name: defs.OptionPingType},
+				&cli.BoolFlag{Name: defs.OptionDual},
+				&cli.BoolFlag{Name: defs.OptionIPv4},
+				&cli.BoolFlag{Name: defs.OptionIPv6},
+			}, tt.args...)
+
+			got := runParams(c, tt.pingType)
+			if got.PingType != tt.wantPing || got.Stack != tt.wantStack {
+				t.Errorf("runParams() ping type = %s, stack = %s; want %s, %s", got.PingType, got.Stack, tt.wantPing, tt.wantStack)
+			}
+		})
+	}
+}
+
+func TestCSVRows(t *testing.T) {
+	results := []defs.Result{{ID: "1"}, {ID: "2"}}
+	params := defs.RunParams{PingType: "udp", Protocol: "h2"}
+
+	rows := csvRows(results, params)
+	for i, row := range rows {
+		if row.ID != results[i].ID || row.SchemaVersion != defs.ReportSchemaVersion || row.Run != params {
+			t.Errorf("row %d = %+v, want the result %s with the schema version and run parameters", i, row, results[i].ID)
+		}
+	}
+	if results[0].SchemaVersion != 0 || results[0].Run != (defs.RunParams{}) {
+		t.Errorf("csvRows() modified the results: %+v", results[0])
+	}
+}
+
+// testServers returns a server whose target is the httptest server
+func testServers(t *testing.T, srv *httptest.Server) []defs.Server {
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return []defs.Server{{ID: "1", Name: "test", Target: host, Port: uint16(p), Type: defs.Perception}}
+}
+
+// speedTestContext returns a context for doSpeedTest with the test options parsed from args
+func speedTestContext(t *testing.T, args ...string) *cli.Context {
+	return testContext(t, []cli.Flag{
+		&cli.IntFlag{Name: defs.OptionPingCount},
+		&cli.IntFlag{Name: defs.OptionDuration},
+		&cli.BoolFlag{Name: defs.OptionNoDownload},
+		&cli.BoolFlag{Name: defs.OptionNoUpload},
+		&cli.BoolFlag{Name: defs.OptionNoBufferbloat},
+	}, args...)
+}
+
+func TestSpeedTestEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	servers := testServers(t, srv)
+	host := servers[0].Target
+	c := speedTestContext(t, "--ping-count", "2", "--no-download", "--no-upload")
+
+	var buf bytes.Buffer
+	reps, err := doSpeedTest(context.Background(), c, servers, "ip", true, defs.TCP, nil, nil, defs.NewEventWriter(&buf))
+	if err != nil {
+		t.Fatalf("doSpeedTest() error = %v", err)
+	}
+
+	type eventLine struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	var types []string
+	var events []eventLine
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var event eventLine
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		types = append(types, event.Type)
+		events = append(events, event)
+	}
+	want := []string{defs.EventServerSelected, defs.EventPingSample, defs.EventPingSample, defs.EventResult, defs.EventDone}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("event types = %v, want %v", types, want)
+	}
+
+	var server defs.ServerEvent
+	json.Unmarshal(events[0].Data, &server)
+	if server.ID != "1" || server.Target != host {
+		t.Errorf("server-selected data = %+v, want server 1 at %s", server, host)
+	}
+	for i, event := range events[1:3] {
+		var ping defs.PingEvent
+		json.Unmarshal(event.Data, &ping)
+		if ping.Server != "1" || ping.Seq != i || ping.RTT <= 0 {
+			t.Errorf("ping-sample %d data = %+v, want server 1 with a RTT", i, ping)
+		}
+	}
+	var result defs.Result
+	json.Unmarshal(events[3].Data, &result)
+	if len(reps) != 1 || result.ID != "1" || result.PingType != "tcp" || result.Ping != reps[0].Ping {
+		t.Errorf("result data = %+v, want the result of server 1 %+v", result, reps)
+	}
+	var done defs.DoneEvent
+	json.Unmarshal(events[4].Data, &done)
+	if done != (defs.DoneEvent{Results: 1}) {
+		t.Errorf("done data = %+v, want 1 result", done)
+	}
+}
+
+func TestSpeedTestInterrupted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/download") {
+			return
+		}
+		buf := make([]byte, 32*1024)
+		for r.Context().Err() == nil {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		duration string
+		// cancelAfter is when the test is interrupted, before it starts if negative and never if 0
+		cancelAfter     time.Duration
+		wantResults     int
+		wantInterrupted bool
+	}{
+		{"complete", "1", 0, 1, false},
+		{"interrupted before the test", "5", -1, 0, true},
+		{"interrupted during the download", "5", 500 * time.Millisecond, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := speedTestContext(t, "--ping-count", "2", "--duration", tt.duration, "--no-upload", "--no-bufferbloat")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter < 0 {
+				cancel()
+			} else if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			var buf bytes.Buffer
+			start := time.Now()
+			reps, err := doSpeedTest(ctx, c, testServers(t, srv), "ip", true, defs.TCP, nil, nil, defs.NewEventWriter(&buf))
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("doSpeedTest() returned after %s, want it to stop once interrupted", elapsed)
+			}
+
+			var exit cli.ExitCoder
+			if interrupted := errors.As(err, &exit) && exit.ExitCode() == defs.ExitInterrupted; interrupted != tt.wantInterrupted {
+				t.Errorf("doSpeedTest() error = %v, want interrupted %t", err, tt.wantInterrupted)
+			}
+			if len(reps) != tt.wantResults {
+				t.Fatalf("got %d results, want %d", len(reps), tt.wantResults)
+			}
+			for _, rep := range reps {
+				if rep.Download <= 0 {
+					t.Errorf("result = %+v, want the partial download speed", rep)
+				}
+			}
+
+			lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+			var done struct {
+				Type string         `json:"type"`
+				Data defs.DoneEvent `json:"data"`
+			}
+			json.Unmarshal(lines[len(lines)-1], &done)
+			if done.Type != defs.EventDone || done.Data != (defs.DoneEvent{Results: tt.wantResults, Interrupted: tt.wantInterrupted}) {
+				t.Errorf("last event = %+v, want done with %d results, interrupted %t", done, tt.wantResults, tt.wantInterrupted)
+			}
+		})
+	}
+}
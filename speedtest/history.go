@@ -0,0 +1,229 @@
+package speedtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// historyTimeLayouts are the layouts accepted by --since and --until, in local time unless an offset is given
+var historyTimeLayouts = []string{time.RFC3339, time.DateTime, "2006-01-02 15:04", time.DateOnly}
+
+// historyFile returns the path of the history file under --history-dir, or under the user's data directory by default
+func historyFile(c *cli.Context) string {
+	dir := c.String(defs.OptionHistoryDir)
+	if dir == "" {
+		if base, err := userDataDir(); err == nil {
+			dir = filepath.Join(base, appDirName)
+		} else {
+			dir = filepath.Join(os.TempDir(), appDirName)
+		}
+	}
+	return filepath.Join(dir, defs.HistoryFileName)
+}
+
+// userDataDir returns the directory for the user's data, i.e. $XDG_DATA_HOME or ~/.local/share on Unix, %LocalAppData%
+// on Windows and ~/Library/Application Support on macOS
+func userDataDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return "", errors.New("%LocalAppData% is not defined")
+	case "darwin", "ios":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Application Support"), nil
+	default:
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			return dir, nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".local", "share"), nil
+	}
+}
+
+// recordHistory appends the results of a completed run to the history, unless --no-history is given. The results of
+// --trace-only are not recorded as they have no speeds
+func recordHistory(c *cli.Context, results []defs.Result, ispInfo *defs.IPInfoResponse, pingType defs.PingType) {
+	if c.Bool(defs.OptionNoHistory) || c.Bool(defs.OptionTraceOnly) || len(results) == 0 {
+		return
+	}
+
+	params := runParams(c, pingType)
+	entries := make([]defs.HistoryEntry, 0, len(results))
+	for _, rep := range results {
+		entries = append(entries, defs.HistoryEntry{Result: rep, Client: ispInfo, Params: params})
+	}
+
+	file := historyFile(c)
+	if err := defs.AppendHistory(file, entries); err != nil {
+		log.Warnf("Error when recording history: %s", err)
+		return
+	}
+	log.Debugf("Recorded %d results to %s", len(entries), file)
+}
+
+// History is the action of the history subcommand, which lists the recorded results matching the filters, or their
+// statistics per day or hour, as a table or exported to CSV or JSON
+func History(c *cli.Context) error {
+	if c.Bool(defs.OptionDebug) {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	var filter defs.HistoryFilter
+	filter.Servers = c.StringSlice(defs.OptionServer)
+	filter.ISP = c.String(defs.OptionISP)
+	if since := c.String(defs.OptionSince); since != "" {
+		t, _, err := parseHistoryTime(since)
+		if err != nil {
+			log.Errorf("Invalid time: %s", since)
+			return err
+		}
+		filter.Since = t
+	}
+	if until := c.String(defs.OptionUntil); until != "" {
+		t, dateOnly, err := parseHistoryTime(until)
+		if err != nil {
+			log.Errorf("Invalid time: %s", until)
+			return err
+		}
+		// a date is included as a whole
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		filter.Until = t
+	}
+
+	var layout string
+	switch aggregate := c.String(defs.OptionAggregate); aggregate {
+	case "":
+	case "day":
+		layout = time.DateOnly
+	case "hour":
+		layout = "2006-01-02 15:00"
+	default:
+		log.Errorf("Unknown aggregate period: %s", aggregate)
+		return errors.New("invalid aggregate setting")
+	}
+
+	file := historyFile(c)
+	entries, err := defs.ReadHistory(file, filter)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Warnf("No history recorded in %s", file)
+			return nil
+		}
+		log.Errorf("Error when reading history: %s", err)
+		return err
+	}
+
+	if layout != "" {
+		aggregates := defs.AggregateHistory(entries, layout)
+		switch {
+		case c.Bool(defs.OptionCSV):
+			return exportCSV(&aggregates)
+		case c.Bool(defs.OptionJSON):
+			return exportJSON(aggregates)
+		}
+		printHistoryAggregates(aggregates)
+		return nil
+	}
+
+	switch {
+	case c.Bool(defs.OptionCSV):
+		results := make([]defs.Result, 0, len(entries))
+		for _, entry := range entries {
+			results = append(results, csvRows([]defs.Result{entry.Result}, entry.Params)...)
+		}
+		return exportCSV(&results)
+	case c.Bool(defs.OptionJSON):
+		if entries == nil {
+			entries = []defs.HistoryEntry{}
+		}
+		return exportJSON(entries)
+	}
+	printHistory(entries)
+	return nil
+}
+
+// parseHistoryTime parses the time of --since or --until, and reports whether only a date is given
+func parseHistoryTime(val string) (time.Time, bool, error) {
+	var err error
+	for _, layout := range historyTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, val, time.Local); err == nil {
+			return t, layout == time.DateOnly, nil
+		}
+	}
+	return time.Time{}, false, err
+}
+
+// printHistory prints the history entries as a table
+func printHistory(entries []defs.HistoryEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No results found")
+		return
+	}
+
+	speed := func(mbps float64) string {
+		if mbps == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f", mbps)
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Time", "ID", "Name", "ISP", "Stack", "Ping", "Jitter", "Loss%", "Download", "Upload"})
+	for _, entry := range entries {
+		rep := &entry.Result
+		t.AppendRow(table.Row{rep.Timestamp.Local().Format(time.DateTime), rep.ID, rep.Name, rep.ISP, rep.Stack,
+			fmt.Sprintf("%.2f", rep.Ping), fmt.Sprintf("%.2f", rep.Jitter), fmt.Sprintf("%.2f", rep.PacketLoss),
+			speed(rep.Download), speed(rep.Upload)})
+	}
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.Render()
+}
+
+// printHistoryAggregates prints the statistics per period as a table
+func printHistoryAggregates(aggregates []defs.HistoryAggregate) {
+	if len(aggregates) == 0 {
+		fmt.Println("No results found")
+		return
+	}
+
+	stats := func(mean, min, max float64) string {
+		if mean == 0 && min == 0 && max == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f / %.2f / %.2f", mean, min, max)
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Period", "Tests", "Ping (mean / min / max)", "Download (mean / min / max)", "Upload (mean / min / max)"})
+	for _, agg := range aggregates {
+		t.AppendRow(table.Row{agg.Period, agg.Tests, stats(agg.PingMean, agg.PingMin, agg.PingMax),
+			stats(agg.DownloadMean, agg.DownloadMin, agg.DownloadMax), stats(agg.UploadMean, agg.UploadMin, agg.UploadMax)})
+	}
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.Render()
+}
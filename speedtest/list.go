@@ -0,0 +1,182 @@
+package speedtest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// listSortKeys are the keys --list-sort accepts, with the function comparing the servers by each
+var listSortKeys = map[string]func(a, b *defs.ServerInfo) bool{
+	"id": func(a, b *defs.ServerInfo) bool {
+		// numeric IDs are compared as numbers
+		if x, err := strconv.Atoi(a.ID); err == nil {
+			if y, err := strconv.Atoi(b.ID); err == nil {
+				return x < y
+			}
+		}
+		return a.ID < b.ID
+	},
+	"name":     func(a, b *defs.ServerInfo) bool { return a.Name < b.Name },
+	"province": func(a, b *defs.ServerInfo) bool { return a.ProvinceID < b.ProvinceID },
+	"city":     func(a, b *defs.ServerInfo) bool { return a.City < b.City },
+	"isp":      func(a, b *defs.ServerInfo) bool { return a.ISPID < b.ISPID },
+	"type":     func(a, b *defs.ServerInfo) bool { return a.Type < b.Type },
+}
+
+// listFilter selects the servers shown by --list, its zero values match every server
+type listFilter struct {
+	province uint8
+	isp      uint8
+	typ      string
+	stack    defs.Stack
+	name     string
+	sort     string
+}
+
+// parseListFilter parses the filters and the sort key of --list
+func parseListFilter(c *cli.Context) (*listFilter, error) {
+	f := &listFilter{sort: c.String(defs.OptionListSort), name: strings.ToLower(c.String(defs.OptionListName))}
+
+	if prov := c.String(defs.OptionListProvince); prov != "" {
+		provinceMap := initProvinceMap()
+		if id, err := strconv.ParseUint(prov, 10, 8); err == nil {
+			f.province = uint8(id)
+		} else if errors.Is(err, strconv.ErrRange) {
+			log.Errorf("Province ID out of range: %s", prov)
+			return nil, fmt.Errorf("invalid %s setting", defs.OptionListProvince)
+		} else {
+			for _, p := range provinceMap {
+				if strings.EqualFold(p.Code, prov) {
+					f.province = p.ID
+				}
+			}
+			if f.province == 0 {
+				f.province = MatchProvince(prov, &provinceMap)
+			}
+		}
+		if _, ok := provinceMap[f.province]; !ok || f.province == 0 {
+			log.Errorf("Unknown province: %s", prov)
+			return nil, fmt.Errorf("invalid %s setting", defs.OptionListProvince)
+		}
+	}
+
+	if isp := c.String(defs.OptionListISP); isp != "" {
+		for _, i := range defs.ISPMap {
+			if i.ID != 0 && (isp == strconv.Itoa(int(i.ID)) || isp == strconv.Itoa(int(i.ASN)) ||
+				strings.EqualFold(isp, i.Short) || strings.EqualFold(isp, i.Code)) {
+				f.isp = i.ID
+				break
+			}
+		}
+		if f.isp == 0 {
+			f.isp = MatchISP(isp)
+		}
+		if f.isp == 0 {
+			log.Errorf("Unknown ISP: %s", isp)
+			return nil, fmt.Errorf("invalid %s setting", defs.OptionListISP)
+		}
+	}
+
+	if typ := c.String(defs.OptionListType); typ != "" {
+		for _, t := range []defs.ServerType{defs.GlobalSpeed, defs.Perception, defs.WirelessSpeed, defs.StaticFile} {
+			if strings.EqualFold(typ, t.String()) {
+				f.typ = t.String()
+			}
+		}
+		if f.typ == "" {
+			log.Errorf("Unknown server type: %s", typ)
+			return nil, fmt.Errorf("invalid %s setting", defs.OptionListType)
+		}
+	}
+
+	switch stack := c.String(defs.OptionListStack); stack {
+	case "":
+	case "ipv4":
+		f.stack = defs.StackIPv4
+	case "ipv6":
+		f.stack = defs.StackIPv6
+	case "dual":
+		f.stack = defs.StackDual
+	default:
+		log.Errorf("Unknown stack: %s", stack)
+		return nil, fmt.Errorf("invalid %s setting", defs.OptionListStack)
+	}
+
+	if _, ok := listSortKeys[f.sort]; f.sort != "" && !ok {
+		log.Errorf("Unknown sort key: %s", f.sort)
+		return nil, fmt.Errorf("invalid %s setting", defs.OptionListSort)
+	}
+
+	return f, nil
+}
+
+// match checks whether the server is selected by the filter
+func (f *listFilter) match(s *defs.ServerInfo) bool {
+	switch {
+	case f.province != 0 && s.ProvinceID != f.province:
+		return false
+	case f.isp != 0 && s.ISPID != f.isp:
+		return false
+	case f.typ != "" && s.Type != f.typ:
+		return false
+	case f.name != "" && !strings.Contains(strings.ToLower(s.Name), f.name):
+		return false
+	case f.stack == defs.StackIPv4 && s.IP == "",
+		f.stack == defs.StackIPv6 && s.IPv6 == "",
+		f.stack == defs.StackDual && (s.IP == "" || s.IPv6 == ""):
+		return false
+	}
+	return true
+}
+
+// listServers prints the servers selected by the filter as a table, or as CSV or JSON with every field if --csv or
+// --json is given
+func listServers(c *cli.Context, servers []defs.Server, filter *listFilter) error {
+	infos := make([]defs.ServerInfo, 0, len(servers))
+	for i := range servers {
+		if info := servers[i].Info(); filter.match(&info) {
+			infos = append(infos, info)
+		}
+	}
+	if less, ok := listSortKeys[filter.sort]; ok {
+		sort.SliceStable(infos, func(i, j int) bool { return less(&infos[i], &infos[j]) })
+	}
+
+	switch {
+	case c.Bool(defs.OptionCSV):
+		return exportCSV(&infos)
+	case c.Bool(defs.OptionJSON):
+		return exportJSON(infos)
+	}
+
+	log.Infoln()
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"ID", "Name", "Prov", "City", "ISP", "v4", "v6"})
+
+	for _, svr := range infos {
+		v4, v6 := "N", "N"
+		if svr.IP != "" {
+			v4 = "Y"
+		}
+		if svr.IPv6 != "" {
+			v6 = "Y"
+		}
+		t.AppendRow(table.Row{svr.ID, svr.Name, svr.Province, svr.City, svr.ISP, v4, v6})
+	}
+
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.Render()
+	return nil
+}
@@ -0,0 +1,110 @@
+package speedtest
+
+import (
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// listContext returns a context with the --list filters parsed from args
+func listContext(t *testing.T, args ...string) *cli.Context {
+	return testContext(t, []cli.Flag{
+		&cli.StringFlag{Name: defs.OptionListProvince},
+		&cli.StringFlag{Name: defs.OptionListISP},
+		&cli.StringFlag{Name: defs.OptionListType},
+		&cli.StringFlag{Name: defs.OptionListStack},
+		&cli.StringFlag{Name: defs.OptionListName},
+		&cli.StringFlag{Name: defs.OptionListSort},
+	}, args...)
+}
+
+func TestParseListFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    listFilter
+		wantErr bool
+	}{
+		{name: "no filter", want: listFilter{}},
+		{name: "province ID", args: []string{"--list-province", "11"}, want: listFilter{province: 11}},
+		{name: "province code", args: []string{"--list-province", "BJ"}, want: listFilter{province: 11}},
+		{name: "province name", args: []string{"--list-province", "北京"}, want: listFilter{province: 11}},
+		{name: "unknown province ID", args: []string{"--list-province", "99"}, wantErr: true},
+		{name: "province ID 0", args: []string{"--list-province", "0"}, wantErr: true},
+		{name: "province ID out of range", args: []string{"--list-province", "267"}, wantErr: true},
+		{name: "negative province ID", args: []string{"--list-province", "-245"}, wantErr: true},
+		{name: "unknown province", args: []string{"--list-province", "nowhere"}, wantErr: true},
+		{name: "ISP ID", args: []string{"--list-isp", "2"}, want: listFilter{isp: defs.UNICOM.ID}},
+		{name: "ISP ASN", args: []string{"--list-isp", "9808"}, want: listFilter{isp: defs.MOBILE.ID}},
+		{name: "ISP code", args: []string{"--list-isp", "CT"}, want: listFilter{isp: defs.TELECOM.ID}},
+		{name: "ISP name", args: []string{"--list-isp", "联通"}, want: listFilter{isp: defs.UNICOM.ID}},
+		{name: "unknown ISP", args: []string{"--list-isp", "nobody"}, wantErr: true},
+		{name: "type", args: []string{"--list-type", "Perception"}, want: listFilter{typ: "perception"}},
+		{name: "unknown type", args: []string{"--list-type", "ftp"}, wantErr: true},
+		{name: "stack", args: []string{"--list-stack", "dual"}, want: listFilter{stack: defs.StackDual}},
+		{name: "unknown stack", args: []string{"--list-stack", "ipv5"}, wantErr: true},
+		{name: "name", args: []string{"--list-name", "Beijing"}, want: listFilter{name: "beijing"}},
+		{name: "sort", args: []string{"--list-sort", "city"}, want: listFilter{sort: "city"}},
+		{name: "unknown sort key", args: []string{"--list-sort", "speed"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseListFilter(listContext(t, tt.args...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseListFilter(%v) error = %v, wantErr %t", tt.args, err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("parseListFilter(%v) = %+v, want %+v", tt.args, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListFilterMatch(t *testing.T) {
+	server := defs.ServerInfo{
+		Name:       "Beijing Unicom",
+		IP:         "192.0.2.1",
+		ProvinceID: 11,
+		ISPID:      defs.UNICOM.ID,
+		Type:       "perception",
+	}
+	v6Only := server
+	v6Only.IP, v6Only.IPv6 = "", "2001:db8::1"
+	dual := server
+	dual.IPv6 = "2001:db8::1"
+
+	tests := []struct {
+		name   string
+		filter listFilter
+		server defs.ServerInfo
+		want   bool
+	}{
+		{"no filter", listFilter{}, server, true},
+		{"province", listFilter{province: 11}, server, true},
+		{"other province", listFilter{province: 12}, server, false},
+		{"ISP", listFilter{isp: defs.UNICOM.ID}, server, true},
+		{"other ISP", listFilter{isp: defs.MOBILE.ID}, server, false},
+		{"type", listFilter{typ: "perception"}, server, true},
+		{"other type", listFilter{typ: "globalspeed"}, server, false},
+		{"name", listFilter{name: "unicom"}, server, true},
+		{"other name", listFilter{name: "shanghai"}, server, false},
+		{"IPv4", listFilter{stack: defs.StackIPv4}, server, true},
+		{"no IPv4", listFilter{stack: defs.StackIPv4}, v6Only, false},
+		{"IPv6", listFilter{stack: defs.StackIPv6}, v6Only, true},
+		{"no IPv6", listFilter{stack: defs.StackIPv6}, server, false},
+		{"dual stack", listFilter{stack: defs.StackDual}, dual, true},
+		{"single stack", listFilter{stack: defs.StackDual}, server, false},
+		{"every filter", listFilter{province: 11, isp: defs.UNICOM.ID, typ: "perception", name: "beijing", stack: defs.StackDual}, dual, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(&tt.server); got != tt.want {
+				t.Errorf("match(%+v) with %+v = %t, want %t", tt.server, tt.filter, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package speedtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// loadLocalServers reads the server definitions from a JSON or YAML file, which is either a list of servers or an
+// object with the list under `servers`. The fields are the same as the servers from the Core API
+func loadLocalServers(path string) ([]defs.Server, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is converted to JSON so that both are decoded with the JSON field names of defs.Server
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".json" {
+		var doc interface{}
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if b, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+	}
+
+	var servers []defs.Server
+	if err := json.Unmarshal(b, &servers); err != nil {
+		var wrapped struct {
+			Servers []defs.Server `json:"servers"`
+		}
+		if errWrapped := json.Unmarshal(b, &wrapped); errWrapped != nil {
+			return nil, err
+		}
+		servers = wrapped.Servers
+	}
+
+	ids := make(map[string]bool, len(servers))
+	for i := range servers {
+		server := &servers[i]
+		if server.ID == "" {
+			server.ID = "local-" + strconv.Itoa(i+1)
+		}
+		if ids[server.ID] {
+			return nil, fmt.Errorf("duplicate server ID: %s", server.ID)
+		}
+		ids[server.ID] = true
+
+		if server.Name == "" {
+			server.Name = server.ID
+		}
+		if server.Type > defs.StaticFile {
+			return nil, fmt.Errorf("server %s has unknown type: %d", server.ID, server.Type)
+		}
+		if server.IP == "" && server.IPv6 == "" && server.Host == "" {
+			return nil, fmt.Errorf("server %s has neither IP nor host", server.ID)
+		}
+		if server.Port == 0 {
+			server.Port = 80
+			if server.HTTPS {
+				server.Port = 443
+			}
+		}
+
+		// static file servers are resolved later, the others need an address to be tested
+		if server.Type != defs.StaticFile && server.IP == "" && server.IPv6 == "" {
+			server.IP = resolveHost("ip4", server.Host)
+			server.IPv6 = resolveHost("ip6", server.Host)
+			if server.IP == "" && server.IPv6 == "" {
+				log.Warnf("Failed to resolve host %s of server %s (%s)", server.Host, server.Name, server.ID)
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		return nil, errors.New("no server defined")
+	}
+
+	return servers, nil
+}
+
+// matchLocalServers returns the local servers with one of the given IDs, or in one of the given `province@isp` groups
+// where 0 matches any province or ISP. Each group is returned separately like in the server list of the Core API
+func matchLocalServers(servers []defs.Server, ids, groups []string) []defs.ServerResponse {
+	var ret []defs.ServerResponse
+
+	if len(ids) > 0 {
+		var nodes []defs.Server
+		for _, server := range servers {
+			if contains(ids, server.ID) {
+				nodes = append(nodes, server)
+			}
+		}
+		if len(nodes) > 0 {
+			ret = append(ret, defs.ServerResponse{Node: nodes})
+		}
+	}
+
+	for _, g := range groups {
+		pi := strings.Split(g, "@")
+		if len(pi) != 2 {
+			continue
+		}
+		province, _ := strconv.Atoi(pi[0])
+		isp, _ := strconv.Atoi(pi[1])
+
+		var nodes []defs.Server
+		for _, server := range servers {
+			if (province == 0 || int(server.Prov) == province) && (isp == 0 || int(server.ISP) == isp) {
+				nodes = append(nodes, server)
+			}
+		}
+		if len(nodes) > 0 {
+			ret = append(ret, defs.ServerResponse{Group: g, Node: nodes})
+		}
+	}
+
+	return ret
+}
+
+// mergeServers returns the local servers followed by the ones from the Core API, the local ones taking precedence when
+// the IDs are the same
+func mergeServers(local, remote []defs.Server) []defs.Server {
+	ret := append([]defs.Server{}, local...)
+	for _, server := range remote {
+		dup := false
+		for _, l := range local {
+			if l.ID == server.ID {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			ret = append(ret, server)
+		}
+	}
+	return ret
+}
+
+// mergeServerGroups merges the matched local servers into the server list from the Core API, group by group
+func mergeServerGroups(local, remote []defs.ServerResponse) []defs.ServerResponse {
+	ret := append([]defs.ServerResponse{}, remote...)
+	for _, l := range local {
+		merged := false
+		for i := range ret {
+			if ret[i].Group == l.Group {
+				ret[i].Node = mergeServers(l.Node, ret[i].Node)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
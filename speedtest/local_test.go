@@ -0,0 +1,131 @@
+package speedtest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+func TestLoadLocalServers(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		wantIDs   []string
+		wantNames []string
+		wantPorts []uint16
+		wantErr   bool
+	}{
+		{
+			name:      "JSON array",
+			file:      "servers.json",
+			content:   `[{"id": "a", "name": "A", "ip": "192.0.2.1", "port": 8080}, {"ip": "192.0.2.2", "https": true}]`,
+			wantIDs:   []string{"a", "local-2"},
+			wantNames: []string{"A", "local-2"},
+			wantPorts: []uint16{8080, 443},
+		},
+		{
+			name:      "YAML with servers key",
+			file:      "servers.yaml",
+			content:   "servers:\n  - id: b\n    ipv6: 2001:db8::1\n    type: 1\n",
+			wantIDs:   []string{"b"},
+			wantNames: []string{"b"},
+			wantPorts: []uint16{80},
+		},
+		{name: "duplicate ID", file: "servers.json", content: `[{"id": "a", "ip": "192.0.2.1"}, {"id": "a", "ip": "192.0.2.2"}]`, wantErr: true},
+		{name: "unknown type", file: "servers.json", content: `[{"id": "a", "ip": "192.0.2.1", "type": 9}]`, wantErr: true},
+		{name: "no address", file: "servers.json", content: `[{"id": "a"}]`, wantErr: true},
+		{name: "empty", file: "servers.json", content: `[]`, wantErr: true},
+		{name: "invalid YAML", file: "servers.yml", content: "servers: [", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			servers, err := loadLocalServers(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadLocalServers() error = %v, want error %t", err, tt.wantErr)
+			}
+
+			var ids, names []string
+			var ports []uint16
+			for _, s := range servers {
+				ids, names, ports = append(ids, s.ID), append(names, s.Name), append(ports, s.Port)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || !reflect.DeepEqual(names, tt.wantNames) || !reflect.DeepEqual(ports, tt.wantPorts) {
+				t.Errorf("loadLocalServers() = %v %v %v, want %v %v %v", ids, names, ports, tt.wantIDs, tt.wantNames, tt.wantPorts)
+			}
+		})
+	}
+}
+
+func TestMergeServers(t *testing.T) {
+	tests := []struct {
+		name   string
+		local  []defs.Server
+		remote []defs.Server
+		want   []string
+	}{
+		{"no local", nil, []defs.Server{{ID: "1"}, {ID: "2"}}, []string{"1", "2"}},
+		{"no remote", []defs.Server{{ID: "a"}}, nil, []string{"a"}},
+		{"local first", []defs.Server{{ID: "a"}}, []defs.Server{{ID: "1"}}, []string{"a", "1"}},
+		{"local takes precedence", []defs.Server{{ID: "1", Name: "local"}}, []defs.Server{{ID: "1"}, {ID: "2"}}, []string{"1:local", "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, s := range mergeServers(tt.local, tt.remote) {
+				id := s.ID
+				if s.Name != "" {
+					id += ":" + s.Name
+				}
+				got = append(got, id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeServers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchLocalServers(t *testing.T) {
+	servers := []defs.Server{
+		{ID: "a", Prov: 11, ISP: 1},
+		{ID: "b", Prov: 11, ISP: 2},
+		{ID: "c", Prov: 31, ISP: 1},
+	}
+
+	tests := []struct {
+		name   string
+		ids    []string
+		groups []string
+		want   map[string][]string
+	}{
+		{"by ID", []string{"a", "c", "x"}, nil, map[string][]string{"": {"a", "c"}}},
+		{"by province", nil, []string{"11@0"}, map[string][]string{"11@0": {"a", "b"}}},
+		{"by ISP", nil, []string{"0@1"}, map[string][]string{"0@1": {"a", "c"}}},
+		{"no match", []string{"x"}, []string{"44@3", "invalid"}, map[string][]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string][]string{}
+			for _, g := range matchLocalServers(servers, tt.ids, tt.groups) {
+				for _, s := range g.Node {
+					got[g.Group] = append(got[g.Group], s.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchLocalServers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
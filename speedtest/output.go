@@ -0,0 +1,150 @@
+package speedtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// outputDialTimeout is the timeout of connecting to the TCP endpoint of --output
+const outputDialTimeout = 10 * time.Second
+
+// writeFormatted writes the results in the time series format of --format to --output, or to stdout if not given. The
+// headers are added to the requests to an HTTP endpoint
+func writeFormatted(format, output string, headers []string, results []defs.Result) error {
+	w, err := openOutput(output, headers)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "influx":
+		err = defs.WriteInflux(w, results)
+	case "graphite":
+		err = defs.WriteGraphite(w, results)
+	default:
+		err = fmt.Errorf("unknown format: %s", format)
+	}
+
+	if errClose := w.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// exportCSV writes the rows to stdout as CSV with a header
+func exportCSV(rows interface{}) error {
+	b, err := gocsv.MarshalBytes(rows)
+	if err != nil {
+		log.Errorf("Error generating CSV report: %s", err)
+		return err
+	}
+	os.Stdout.Write(b)
+	return nil
+}
+
+// exportJSON writes v to stdout as JSON
+func exportJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("Error generating JSON report: %s", err)
+		return err
+	}
+	os.Stdout.Write(b)
+	return nil
+}
+
+// openOutput opens the endpoint the formatted results are written to, which is a `tcp://` or `udp://` address or an
+// `http://` or `https://` URL the results are posted to once closed. Stdout is used if the endpoint is empty
+func openOutput(output string, headers []string) (io.WriteCloser, error) {
+	if output == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	u, err := url.Parse(output)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		return net.DialTimeout("tcp", u.Host, outputDialTimeout)
+	case "udp":
+		// each line is written at once, and thus sent as a datagram
+		return net.Dial("udp", u.Host)
+	case "http", "https":
+		return &httpOutput{url: output, headers: headers}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output: %s", output)
+	}
+}
+
+// validOutput checks the endpoint of --output without connecting to it
+func validOutput(output string) bool {
+	u, err := url.Parse(output)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "tcp" || u.Scheme == "udp" || u.Scheme == "http" || u.Scheme == "https"
+}
+
+// nopCloser is an io.WriteCloser which does not close the underlying writer
+type nopCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer
+func (nopCloser) Close() error {
+	return nil
+}
+
+// httpOutput buffers the formatted results, and posts them to the URL once closed
+type httpOutput struct {
+	url     string
+	headers []string
+	buf     bytes.Buffer
+}
+
+// Write implements io.Writer
+func (o *httpOutput) Write(p []byte) (int, error) {
+	return o.buf.Write(p)
+}
+
+// Close implements io.Closer
+func (o *httpOutput) Close() error {
+	req, err := http.NewRequest(http.MethodPost, o.url, &o.buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", defs.ApiUA)
+	for _, h := range o.headers {
+		if kv := strings.SplitN(h, ":", 2); len(kv) == 2 {
+			req.Header.Set(kv[0], strings.TrimSpace(kv[1]))
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return nil
+}
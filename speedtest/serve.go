@@ -0,0 +1,106 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// serveShutdownTimeout is how long the running tests are waited for when the backend is stopped
+const serveShutdownTimeout = 5 * time.Second
+
+// Serve runs the speed test backend, serving the endpoints of the given server types until SIGINT/SIGTERM
+func Serve(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if c.Bool(defs.OptionDebug) {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	opts := defs.BackendOptions{MaxClients: c.Int(defs.OptionMaxClients)}
+	for _, name := range c.StringSlice(defs.OptionServeTypes) {
+		for _, name := range strings.Split(name, ",") {
+			switch strings.TrimSpace(name) {
+			case "globalspeed":
+				opts.Types = append(opts.Types, defs.GlobalSpeed)
+			case "perception":
+				opts.Types = append(opts.Types, defs.Perception)
+			case "wirelessspeed":
+				opts.Types = append(opts.Types, defs.WirelessSpeed)
+			default:
+				log.Errorf("Unknown server type: %s", name)
+				return errors.New("invalid server type setting")
+			}
+		}
+	}
+	if opts.MaxClients < 0 {
+		log.Errorf("Max clients cannot be negative: %d is given", opts.MaxClients)
+		return errors.New("invalid max clients setting")
+	}
+
+	cert, key := c.String(defs.OptionTLSCert), c.String(defs.OptionTLSKey)
+	if (cert == "") != (key == "") {
+		return fmt.Errorf("options '%s' and '%s' must be given together", defs.OptionTLSCert, defs.OptionTLSKey)
+	}
+
+	backend := defs.NewBackend(opts)
+	addr := c.String(defs.OptionListen)
+	// connection errors, e.g. from the TCP pings closing without a TLS handshake, are only logged in debug mode
+	errorLog := log.StandardLogger().WriterLevel(log.DebugLevel)
+	defer errorLog.Close()
+	server := &http.Server{Addr: addr, Handler: backend, ErrorLog: stdlog.New(errorLog, "", 0)}
+	var h3 *http3.Server
+
+	errs := make(chan error, 2)
+	if cert != "" {
+		// HTTP/2 is negotiated over TLS, and HTTP/3 is served on the same port over UDP
+		if err := http2.ConfigureServer(server, nil); err != nil {
+			return err
+		}
+		h3 = &http3.Server{Addr: addr, Handler: backend}
+		go func() { errs <- server.ListenAndServeTLS(cert, key) }()
+		go func() { errs <- h3.ListenAndServeTLS(cert, key) }()
+		log.Infof("Serving HTTPS with HTTP/1.1, HTTP/2 and HTTP/3 on %s", addr)
+	} else {
+		// HTTP/2 is served with prior knowledge (h2c) over cleartext
+		server.Handler = h2c.NewHandler(backend, &http2.Server{})
+		go func() { errs <- server.ListenAndServe() }()
+		log.Infof("Serving HTTP with HTTP/1.1 and h2c on %s", addr)
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		log.Info("Shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	if h3 != nil {
+		h3.Close()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("Error when serving: %s", err)
+		return err
+	}
+	return nil
+}
@@ -6,17 +6,18 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
-	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/syndtr/gocapability/capability"
 	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gocarina/gocsv"
@@ -26,6 +27,9 @@ import (
 	"github.com/ztelliot/taierspeed-cli/defs"
 )
 
+// maxVolumeDuration is the upper limit of a transfer test bounded by volume only
+const maxVolumeDuration = 10 * time.Minute
+
 //go:embed province.csv
 var ProvinceListByte []byte
 
@@ -41,9 +45,17 @@ type PingResult struct {
 
 // SpeedTest is the actual main function that handles the speed test(s)
 func SpeedTest(c *cli.Context) error {
+	// cancel the tests on SIGINT/SIGTERM to report partial results, a second signal terminates immediately
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	// check for suppressed output flags
 	var silent bool
-	if c.Bool(defs.OptionSimple) || c.Bool(defs.OptionJSON) || c.Bool(defs.OptionCSV) {
+	if c.Bool(defs.OptionSimple) || c.Bool(defs.OptionJSON) || c.Bool(defs.OptionCSV) || (c.String(defs.OptionFormat) != "" && c.String(defs.OptionOutput) == "") {
 		log.SetLevel(log.WarnLevel)
 		silent = true
 	}
@@ -83,9 +95,14 @@ func SpeedTest(c *cli.Context) error {
 		return nil
 	}
 
-	if req := c.Int(defs.OptionConcurrent); req <= 0 {
-		log.Errorf("Concurrent requests cannot be lower than 1: %d is given", req)
-		return errors.New("invalid concurrent requests setting")
+	if req := c.String(defs.OptionConcurrent); req != "auto" {
+		if n, err := strconv.Atoi(req); err != nil {
+			log.Errorf("Invalid concurrent requests: %s", req)
+			return err
+		} else if n <= 0 {
+			log.Errorf("Concurrent requests cannot be lower than 1: %d is given", n)
+			return errors.New("invalid concurrent requests setting")
+		}
 	}
 
 	if req := c.Int(defs.OptionPingCount); req <= 0 {
@@ -103,6 +120,121 @@ func SpeedTest(c *cli.Context) error {
 		return errors.New("invalid duration setting")
 	}
 
+	if req := c.Int(defs.OptionInterval); req < 10 {
+		log.Errorf("Sample interval cannot be lower than 10 ms: %d is given", req)
+		return errors.New("invalid sample interval setting")
+	}
+
+	if req := c.Int(defs.OptionVolume); req < 0 {
+		log.Errorf("Transfer volume cannot be negative: %d is given", req)
+		return errors.New("invalid volume setting")
+	} else if req == 0 && c.Bool(defs.OptionStopFirst) {
+		log.Errorf("The --%s option requires --%s", defs.OptionStopFirst, defs.OptionVolume)
+		return errors.New("invalid volume setting")
+	}
+
+	if _, _, err := parseWarmup(c.String(defs.OptionWarmup)); err != nil {
+		log.Errorf("Invalid warm-up period: %s", c.String(defs.OptionWarmup))
+		return err
+	}
+
+	if metric := c.String(defs.OptionSpeedMetric); metric != "mean" && metric != "p90" {
+		log.Errorf("Unknown speed metric: %s", metric)
+		return errors.New("invalid speed metric setting")
+	}
+
+	if protocol := c.String(defs.OptionProtocol); protocol != "h1" && protocol != "h2" && protocol != "h3" {
+		log.Errorf("Unknown protocol: %s", protocol)
+		return errors.New("invalid protocol setting")
+	}
+
+	if protocol, ok := parseTraceProtocol(c.String(defs.OptionTraceProtocol)); !ok {
+		log.Errorf("Unknown path analysis probe type: %s", c.String(defs.OptionTraceProtocol))
+		return errors.New("invalid trace protocol setting")
+	} else if protocol == defs.TCP && runtime.GOOS == "windows" {
+		log.Error("TCP path analysis probes are not supported on Windows")
+		return errors.New("invalid trace protocol setting")
+	}
+
+	if count := c.Int(defs.OptionTraceCount); count < 1 {
+		log.Errorf("Path analysis probe count must be at least 1: %d is given", count)
+		return errors.New("invalid trace count setting")
+	}
+
+	if hops := c.Int(defs.OptionTraceHops); hops < 1 || hops > 255 {
+		log.Errorf("Path analysis hops must be between 1 and 255: %d is given", hops)
+		return errors.New("invalid trace hops setting")
+	}
+
+	if probes := c.Int(defs.OptionTraceCount) * c.Int(defs.OptionTraceHops); probes > defs.MaxTraceProbes {
+		log.Errorf("Path analysis can send at most %d probes: %d probes to %d hops are given", defs.MaxTraceProbes,
+			c.Int(defs.OptionTraceCount), c.Int(defs.OptionTraceHops))
+		return errors.New("invalid trace count setting")
+	}
+
+	if format := c.String(defs.OptionFormat); format != "" && format != "influx" && format != "graphite" && format != "jsonl" {
+		log.Errorf("Unknown output format: %s", format)
+		return errors.New("invalid format setting")
+	} else if output := c.String(defs.OptionOutput); output != "" {
+		if format == "" {
+			log.Errorf("The --%s option requires --%s", defs.OptionOutput, defs.OptionFormat)
+			return errors.New("invalid output setting")
+		} else if !validOutput(output) {
+			log.Errorf("Invalid output: %s", output)
+			return errors.New("invalid output setting")
+		}
+	}
+
+	if c.String(defs.OptionExporter) != "" {
+		if c.Bool(defs.OptionCSV) || c.Bool(defs.OptionJSON) || c.Bool(defs.OptionList) {
+			log.Errorf("The --%s option cannot be used with --%s, --%s or --%s", defs.OptionExporter, defs.OptionCSV, defs.OptionJSON, defs.OptionList)
+			return errors.New("invalid exporter setting")
+		} else if every, min := c.Int(defs.OptionExporterEvery), c.Int(defs.OptionExporterMin); every < 0 || min < 0 {
+			log.Errorf("Exporter intervals cannot be negative: %d and %d are given", every, min)
+			return errors.New("invalid exporter setting")
+		} else if every > 0 && every < min && c.IsSet(defs.OptionExporterMin) {
+			log.Errorf("The --%s of %d seconds is shorter than the --%s of %d seconds", defs.OptionExporterEvery, every, defs.OptionExporterMin, min)
+			return errors.New("invalid exporter setting")
+		}
+	}
+
+	var filter *listFilter
+	if c.Bool(defs.OptionList) {
+		var err error
+		if filter, err = parseListFilter(c); err != nil {
+			return err
+		}
+	}
+
+	if checksEnabled(c) {
+		for _, name := range thresholdOptions {
+			if val := c.Float64(name); val < 0 {
+				log.Errorf("Threshold --%s cannot be negative: %v is given", name, val)
+				return errors.New("invalid threshold setting")
+			}
+		}
+		if regression := c.Float64(defs.OptionMaxRegression); regression > 100 {
+			log.Errorf("Regression threshold must be between 0 and 100: %v is given", regression)
+			return errors.New("invalid threshold setting")
+		} else if runs := c.Int(defs.OptionBaselineRuns); runs < 1 {
+			log.Errorf("Baseline runs must be at least 1: %d is given", runs)
+			return errors.New("invalid threshold setting")
+		} else if c.String(defs.OptionExporter) != "" || c.Bool(defs.OptionTraceOnly) {
+			log.Errorf("Thresholds cannot be used with --%s or --%s", defs.OptionExporter, defs.OptionTraceOnly)
+			return errors.New("invalid threshold setting")
+		}
+	}
+
+	if ttl := c.Int(defs.OptionCacheTTL); ttl < 0 {
+		log.Errorf("Cache TTL cannot be negative: %d is given", ttl)
+		return errors.New("invalid cache TTL setting")
+	}
+
+	if _, err := defs.ParseJitterAlgorithm(c.String(defs.OptionJitter)); err != nil {
+		log.Errorf("Unknown jitter algorithm: %s", c.String(defs.OptionJitter))
+		return err
+	}
+
 	if c.Bool(defs.OptionNoDownload) || c.Bool(defs.OptionNoUpload) {
 		log.Warnf("The --%s and --%s options are deprecated and will be removed in the future", defs.OptionNoDownload, defs.OptionNoUpload)
 	}
@@ -110,8 +242,36 @@ func SpeedTest(c *cli.Context) error {
 	// HTTP requests timeout
 	http.DefaultClient.Timeout = time.Duration(c.Int(defs.OptionTimeout)) * time.Second
 
+	// with --format jsonl, the events are streamed while testing, including the errors logged from now on
+	var events *defs.EventWriter
+	if c.String(defs.OptionFormat) == "jsonl" {
+		w, err := openOutput(c.String(defs.OptionOutput), c.StringSlice(defs.OptionOutputHeader))
+		if err != nil {
+			log.Errorf("Error opening jsonl output: %s", err)
+			return err
+		}
+		defer func() {
+			// the hook is removed first, as the error of closing the output cannot be written to it
+			log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			if err := w.Close(); err != nil {
+				log.Errorf("Error writing jsonl output: %s", err)
+			}
+		}()
+		events = defs.NewEventWriter(w)
+		log.AddHook(events)
+	}
+
 	forceIPv4 := c.Bool(defs.OptionIPv4)
 	forceIPv6 := c.Bool(defs.OptionIPv6)
+	dualStack := c.Bool(defs.OptionDual)
+	if dualStack && (forceIPv4 || forceIPv6) {
+		log.Errorf("The --%s option cannot be used with --%s or --%s", defs.OptionDual, defs.OptionIPv4, defs.OptionIPv6)
+		return errors.New("invalid stack setting")
+	} else if dualStack && c.String(defs.OptionSource) != "" {
+		// a source address only binds one of the IP versions
+		log.Errorf("The --%s option cannot be used with --%s", defs.OptionDual, defs.OptionSource)
+		return errors.New("invalid stack setting")
+	}
 	var pingType defs.PingType
 	switch c.String(defs.OptionPingType) {
 	case "udp":
@@ -122,6 +282,8 @@ func SpeedTest(c *cli.Context) error {
 		}
 	case "http":
 		pingType = defs.HTTP
+	case "tcp":
+		pingType = defs.TCP
 	default:
 		pingType = defs.ICMP
 		if runtime.GOOS == "linux" {
@@ -149,12 +311,16 @@ func SpeedTest(c *cli.Context) error {
 	case forceIPv6:
 		network = "ip6"
 		stack = defs.StackIPv6
+	case dualStack:
+		network = "ip"
+		stack = defs.StackDual
 	default:
 		network = "ip"
 		stack = defs.StackAll
 	}
 
 	transport := http.DefaultTransport.(*http.Transport).Clone()
+	var defaultDialer *net.Dialer
 
 	// bind to source IP address or interface if given, or if ipv4/ipv6 is forced
 	if src, iface := c.String(defs.OptionSource), c.String(defs.OptionInterface); src != "" || iface != "" || forceIPv4 || forceIPv6 {
@@ -179,14 +345,13 @@ func SpeedTest(c *cli.Context) error {
 			localTCPAddr = &net.TCPAddr{IP: addr.IP}
 		}
 
-		var defaultDialer *net.Dialer
 		var dialContext func(context.Context, string, string) (net.Conn, error)
 
 		if iface != "" {
 			defaultDialer = newInterfaceDialer(iface)
-			if pingType != defs.HTTP {
-				log.Warnf("ICMP/UDP ping is disabled when using interface binding")
-				pingType = defs.HTTP
+			if pingType == defs.ICMP || pingType == defs.UDP {
+				log.Warnf("ICMP/UDP ping is disabled when using interface binding, will use TCP ping")
+				pingType = defs.TCP
 			}
 		} else {
 			defaultDialer = &net.Dialer{
@@ -223,6 +388,13 @@ func SpeedTest(c *cli.Context) error {
 
 	http.DefaultClient.Transport = transport
 
+	transferClient, transferCloser, err := defs.NewTransferClient(c.String(defs.OptionProtocol), transport, defaultDialer, network)
+	if err != nil {
+		log.Errorf("Failed to set up %s transport: %s", c.String(defs.OptionProtocol), err)
+		return err
+	}
+	defer transferCloser.Close()
+
 	if c.Bool(defs.OptionCheckUpdate) {
 		if latest, err := getVersion(c); err != nil {
 			log.Errorf("Error when fetching latest version: %s", err)
@@ -270,22 +442,40 @@ func SpeedTest(c *cli.Context) error {
 		}
 	}
 
+	// load the local servers if --local-servers is given, the Core API is only used with --merge-servers
+	var localServers []defs.Server
+	useAPI := true
+	if path := c.String(defs.OptionLocalServers); path != "" {
+		log.Infof("Loading servers from %s", path)
+		if localServers, err = loadLocalServers(path); err != nil {
+			log.Errorf("Error when loading local servers: %s", err)
+			return err
+		}
+		useAPI = c.Bool(defs.OptionMergeServers)
+	}
+
 	// fetch the server list JSON and parse it into the `servers` array
-	log.Infof("Retrieving server list")
+	if useAPI {
+		log.Infof("Retrieving server list")
+	}
 
 	excludes := c.StringSlice(defs.OptionExclude)
 	if simple {
-		if serversT, err := getServerMatch(c, ispInfo, stack); err != nil {
+		var serversT []defs.Server
+		if useAPI {
+			serversT, err = getServerMatch(c, ispInfo, stack)
+		}
+		if err != nil {
 			log.Errorf("Error when fetching server list: %s", err)
 			return err
 		} else {
-			serversT = preprocessServers(stack, serversT, excludes)
+			serversT = preprocessServers(stack, mergeServers(localServers, serversT), excludes)
 
 			if c.Bool(defs.OptionList) {
 				servers = append(servers, serversT...)
 			} else {
 				log.Debugf("Find %d servers", len(serversT))
-				if server, ok := selectServer("", serversT, network, c, pingType); ok {
+				if server, ok := selectServer(ctx, "", serversT, network, c, pingType); ok {
 					servers = append(servers, server)
 				}
 			}
@@ -377,10 +567,15 @@ func SpeedTest(c *cli.Context) error {
 			return err
 		}
 
-		groups, err := getServerList(c, &_servers, &_groups, stack)
-		if err != nil {
-			log.Errorf("Error when fetching server list: %s", err)
-			return err
+		var groups []defs.ServerResponse
+		if useAPI {
+			if groups, err = getServerList(c, &_servers, &_groups, stack); err != nil {
+				log.Errorf("Error when fetching server list: %s", err)
+				return err
+			}
+		}
+		if localServers != nil {
+			groups = mergeServerGroups(matchLocalServers(localServers, _servers, _groups), groups)
 		}
 		for _, g := range groups {
 			serversT := preprocessServers(stack, g.Node, excludes)
@@ -398,7 +593,7 @@ func SpeedTest(c *cli.Context) error {
 					logPre := fmt.Sprintf("[%s%s] ", provinceMap[uint8(province)].Short, defs.ISPMap[uint8(isp)].Name)
 					log.Debugf("%sFind %d servers", logPre, len(serversT))
 					if len(serversT) > 0 {
-						if server, ok := selectServer(logPre, serversT, network, c, pingType); ok {
+						if server, ok := selectServer(ctx, logPre, serversT, network, c, pingType); ok {
 							servers = append(servers, server)
 						}
 					}
@@ -408,44 +603,57 @@ func SpeedTest(c *cli.Context) error {
 	}
 
 	log.Debugf("Selected %d servers", len(servers))
+	// an interrupted selection leaves no server, which is not a missing server
+	if ctx.Err() != nil {
+		return cli.Exit("Test interrupted", defs.ExitInterrupted)
+	}
 	if len(servers) == 0 {
 		err := errors.New("specified server(s) not found")
 		log.Errorf("Error when selecting server: %s", err)
 		return err
 	}
 
+	// fill in the province names of the servers for the results
+	for i := range servers {
+		if servers[i].Province == "" && servers[i].Prov != 0 {
+			if provinceMap == nil {
+				provinceMap = initProvinceMap()
+			}
+			servers[i].Province = provinceMap[servers[i].Prov].Short
+		}
+	}
+
 	// if --list is given, list all the servers fetched and exit
 	if c.Bool(defs.OptionList) {
-		if provinceMap == nil {
-			provinceMap = initProvinceMap()
-		}
-		log.Infoln()
-		t := table.NewWriter()
-		t.SetOutputMirror(os.Stdout)
-		t.AppendHeader(table.Row{"ID", "Name", "Prov", "City", "ISP", "v4", "v6"})
-
-		for _, svr := range servers {
-			province := svr.Province
-			if province == "" && svr.Prov != 0 {
-				province = provinceMap[svr.Prov].Short
-			}
-			v4, v6 := "N", "N"
-			if svr.IP != "" {
-				v4 = "Y"
-			}
-			if svr.IPv6 != "" {
-				v6 = "Y"
+		return listServers(c, servers, filter)
+	}
+
+	// if --exporter is given, run the tests on a schedule or on scrape until interrupted
+	if c.String(defs.OptionExporter) != "" {
+		return runExporter(ctx, c, func(ctx context.Context) ([]defs.Result, error) {
+			reps, err := doSpeedTest(ctx, c, servers, network, true, pingType, ispInfo, transferClient, events)
+			if err == nil {
+				recordHistory(c, reps, ispInfo, pingType)
 			}
-			t.AppendRow(table.Row{svr.ID, svr.Name, province, svr.City, defs.ISPMap[svr.ISP].Name, v4, v6})
-		}
+			return reps, err
+		})
+	}
 
-		t.Style().Options.DrawBorder = false
-		t.Style().Options.SeparateColumns = false
-		t.Render()
-		return nil
+	reps, err := doSpeedTest(ctx, c, servers, network, silent, pingType, ispInfo, transferClient, events)
+	if err != nil {
+		return err
 	}
 
-	return doSpeedTest(c, servers, network, silent, pingType, ispInfo)
+	// the checks against the history baseline are run before this run is recorded
+	var checks []defs.Check
+	if checksEnabled(c) {
+		checks = runChecks(c, servers, reps)
+	}
+	recordHistory(c, reps, ispInfo, pingType)
+	if checksEnabled(c) {
+		return reportChecks(c, checks)
+	}
+	return nil
 }
 
 func initProvinceMap() map[uint8]defs.ProvinceInfo {
@@ -458,7 +666,7 @@ func initProvinceMap() map[uint8]defs.ProvinceInfo {
 	return provinceMap
 }
 
-func selectServer(logPre string, servers []defs.Server, network string, c *cli.Context, pingType defs.PingType) (defs.Server, bool) {
+func selectServer(ctx context.Context, logPre string, servers []defs.Server, network string, c *cli.Context, pingType defs.PingType) (defs.Server, bool) {
 	if len(servers) > 10 {
 		r := rand.New(rand.NewSource(time.Now().Unix()))
 		r.Shuffle(len(servers), func(i int, j int) {
@@ -478,7 +686,7 @@ func selectServer(logPre string, servers []defs.Server, network string, c *cli.C
 
 	// spawn 10 concurrent pingers
 	for i := 0; i < 10; i++ {
-		go pingWorker(jobs, results, &wg, c.String(defs.OptionSource), network, pingType)
+		go pingWorker(ctx, jobs, results, &wg, c.String(defs.OptionSource), network, pingType)
 	}
 
 	// send ping jobs to workers
@@ -521,7 +729,7 @@ Loop:
 	return servers[serverIdx], true
 }
 
-func pingWorker(jobs <-chan PingJob, results chan<- PingResult, wg *sync.WaitGroup, srcIp, network string, pingType defs.PingType) {
+func pingWorker(ctx context.Context, jobs <-chan PingJob, results chan<- PingResult, wg *sync.WaitGroup, srcIp, network string, pingType defs.PingType) {
 	for {
 		job := <-jobs
 		server := job.Server
@@ -532,14 +740,14 @@ func pingWorker(jobs <-chan PingJob, results chan<- PingResult, wg *sync.WaitGro
 			server.PingType = pingType
 
 			// if server is up, get ping
-			ping, _, err := server.ICMPPingAndJitter(1, srcIp, network)
+			stats, err := server.ICMPPingAndJitter(ctx, 1, srcIp, network)
 			if err != nil {
 				log.Debugf("Can't ping server %s (%s), skipping", server.Name, server.ID)
 				wg.Done()
 				return
 			}
 			// return result
-			results <- PingResult{Index: job.Index, Ping: ping}
+			results <- PingResult{Index: job.Index, Ping: stats.Avg}
 			wg.Done()
 		} else {
 			log.Debugf("Server %s (%s) seems down, skipping", server.Name, server.ID)
@@ -556,7 +764,22 @@ func preprocessServers(stack defs.Stack, servers []defs.Server, excludes []strin
 		if len(excludes) > 0 && contains(excludes, server.ID) {
 			continue
 		}
-		if server.IPv6 != "" && stack != defs.StackIPv4 {
+		if stack == defs.StackDual {
+			// both addresses are needed to test over IPv4 and IPv6, the server is selected over IPv4
+			if server.Type == defs.StaticFile {
+				if server.IP == "" {
+					server.IP = resolveHost("ip4", server.Host)
+				}
+				if server.IPv6 == "" {
+					server.IPv6 = resolveHost("ip6", server.Host)
+				}
+			}
+			if server.IP == "" || server.IPv6 == "" {
+				log.Debugf("Server %s (%s) is not dual-stack, skipping", server.Name, server.ID)
+				continue
+			}
+			server.Target = server.IP
+		} else if server.IPv6 != "" && stack != defs.StackIPv4 {
 			server.Target = server.IPv6
 		} else if server.IP != "" && stack != defs.StackIPv6 {
 			server.Target = server.IP
@@ -0,0 +1,89 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ztelliot/taierspeed-cli/defs"
+)
+
+// traceTimeout is how long the replies to each round of path analysis probes are waited for
+const traceTimeout = time.Second
+
+// parseTraceProtocol returns the probe type of the path analysis given by name
+func parseTraceProtocol(name string) (defs.PingType, bool) {
+	switch name {
+	case "icmp":
+		return defs.ICMP, true
+	case "udp":
+		return defs.UDP, true
+	case "tcp":
+		return defs.TCP, true
+	default:
+		return defs.ICMP, false
+	}
+}
+
+// runTrace analyses the path to the server and prints the hops as a table unless silent
+func runTrace(ctx context.Context, c *cli.Context, server defs.Server, silent bool) []defs.Hop {
+	protocol, _ := parseTraceProtocol(c.String(defs.OptionTraceProtocol))
+	opts := defs.TraceOptions{
+		Protocol:  protocol,
+		MaxHops:   c.Int(defs.OptionTraceHops),
+		Count:     c.Int(defs.OptionTraceCount),
+		Timeout:   traceTimeout,
+		Source:    c.String(defs.OptionSource),
+		LookupASN: c.Bool(defs.OptionTraceASN),
+	}
+
+	var pb *spinner.Spinner
+	if !silent {
+		pb = spinner.New(spinner.CharSets[11], 100*time.Millisecond)
+		pb.Prefix = "Tracing...  "
+		pb.Start()
+	}
+
+	hops, err := server.Trace(ctx, opts)
+	if pb != nil {
+		pb.Stop()
+	}
+	if err != nil {
+		log.Errorf("Failed to trace the path to server %s (%s): %s", server.Name, server.ID, err)
+		return nil
+	}
+
+	if !silent || c.Bool(defs.OptionSimple) {
+		fmt.Printf("Path:\t\t%d hops to %s (%s probes)\n", len(hops), server.Target, c.String(defs.OptionTraceProtocol))
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		header := table.Row{"#", "Host", "Loss%", "Sent", "Avg", "Best", "Worst", "StDev"}
+		if opts.LookupASN {
+			header = append(header, "Backbone")
+		}
+		t.AppendHeader(header)
+		for _, hop := range hops {
+			host := hop.IP
+			if host == "" {
+				host = "???"
+			}
+			row := table.Row{hop.TTL, host, fmt.Sprintf("%.1f", hop.Loss), hop.Sent,
+				fmt.Sprintf("%.2f", hop.Avg), fmt.Sprintf("%.2f", hop.Min), fmt.Sprintf("%.2f", hop.Max), fmt.Sprintf("%.2f", hop.StdDev)}
+			if opts.LookupASN {
+				row = append(row, hop.Backbone)
+			}
+			t.AppendRow(row)
+		}
+		t.Style().Options.DrawBorder = false
+		t.Style().Options.SeparateColumns = false
+		t.Render()
+	}
+
+	return hops
+}